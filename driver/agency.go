@@ -0,0 +1,73 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack"
+	"github.com/huaweicloud/golangsdk/openstack/identity/v3/tokens"
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+)
+
+// agencyEnvPrefix is set for agency client, so agency token is not overridden by OS_* environment variables
+const agencyEnvPrefix = "OTC_AGENCY_"
+
+func (d *Driver) checkAgencyConfig() error {
+	if d.AgencyName == "" && d.AgencyDomainName == "" && d.DelegatedProject == "" {
+		return nil
+	}
+	if d.AgencyName == "" || d.AgencyDomainName == "" {
+		return fmt.Errorf(errorBothOptions, "AgencyName", "AgencyDomainName")
+	}
+	if d.DelegatedProject == "" {
+		d.DelegatedProject = d.Region
+	}
+	return nil
+}
+
+// agencyToken authenticates as delegated user and returns agency token scoped to delegated project
+func (d *Driver) agencyToken() (token string, authURL string, err error) {
+	opts := d.clientOpts()
+	provider, err := newProviderClient(opts, d.httpClient)
+	if _, ok := err.(golangsdk.ErrDefault401); ok && opts.AuthInfo.Token != "" {
+		if err := d.dropExpiredToken(opts); err != nil {
+			return "", "", err
+		}
+		provider, err = newProviderClient(opts, d.httpClient)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	identity, err := openstack.NewIdentityV3(provider, golangsdk.EndpointOpts{})
+	if err != nil {
+		return "", "", err
+	}
+	agencyToken, err := tokens.Create(identity, &golangsdk.AgencyAuthOptions{
+		TokenID:          provider.Token(),
+		AgencyName:       d.AgencyName,
+		AgencyDomainName: d.AgencyDomainName,
+		DelegatedProject: d.DelegatedProject,
+	}).ExtractToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to assume agency `%s` of domain `%s`: %s", d.AgencyName, d.AgencyDomainName, err)
+	}
+	return agencyToken.ID, provider.IdentityEndpoint, nil
+}
+
+// useAgency switches client options to agency token
+func (d *Driver) useAgency(opts *clientconfig.ClientOpts) error {
+	token, authURL, err := d.agencyToken()
+	if err != nil {
+		return err
+	}
+	opts.Cloud = ""
+	opts.YAMLOpts = nil
+	opts.EnvPrefix = agencyEnvPrefix
+	opts.AuthInfo = &clientconfig.AuthInfo{
+		AuthURL:     authURL,
+		Token:       token,
+		ProjectName: d.DelegatedProject,
+		DomainName:  d.AgencyDomainName,
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package opentelekomcloud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAgencyDriver(iam *fakeIAM) *Driver {
+	driver := newIAMDriver(iam)
+	driver.Username = fakeUsername
+	driver.Password = fakePassword
+	driver.AgencyName = fakeAgencyName
+	driver.AgencyDomainName = fakeAgencyDomain
+	driver.DelegatedProject = fakeDelegatedProject
+	return driver
+}
+
+func TestDriver_Agency(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newAgencyDriver(iam)
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+	assert.True(t, iam.Assumed() > 0)
+
+	// compute API is called for delegated project only
+	server, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Equal(t, "ACTIVE", server.Status)
+}
+
+func TestDriver_AgencyRefresh(t *testing.T) {
+	ttl := 300 * time.Millisecond
+	iam := newFakeIAM(ttl)
+	defer iam.Close()
+
+	driver := newAgencyDriver(iam)
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+	assumed := iam.Assumed()
+
+	time.Sleep(ttl + 100*time.Millisecond)
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Equal(t, assumed+1, iam.Assumed())
+}
+
+func TestDriver_AgencyInvalid(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newAgencyDriver(iam)
+	driver.AgencyName = "not-existing"
+	err := driver.Authenticate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not-existing")
+}
+
+func TestDriver_AgencyConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-token":       "token",
+			"otc-agency-name": fakeAgencyName,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	assert.Error(t, driver.SetConfigFromFlags(flags))
+
+	flags.FlagsValues["otc-agency-domain-name"] = fakeAgencyDomain
+	flags.FlagsValues["otc-credentials-store"] = credentialsStoreConfig
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	assert.Equal(t, defaultRegion, driver.DelegatedProject)
+
+	reloaded := reloadDriver(t, driver)
+	assert.Equal(t, fakeAgencyName, reloaded.AgencyName)
+	assert.Equal(t, fakeAgencyDomain, reloaded.AgencyDomainName)
+	assert.Equal(t, defaultRegion, reloaded.DelegatedProject)
+}
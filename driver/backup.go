@@ -0,0 +1,251 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/huaweicloud/golangsdk"
+)
+
+// Kinds of backup taken before machine removal
+const (
+	backupImage = "image"
+	backupCBR   = "cbr"
+)
+
+// backupsDirName is a directory in storage root, as machine directory is removed with the machine
+const backupsDirName = "otc-backups"
+
+// backupRecord is an entry of backup manifest
+type backupRecord struct {
+	Machine    string    `json:"machine"`
+	InstanceID string    `json:"instance_id"`
+	Kind       string    `json:"kind"`
+	VaultID    string    `json:"vault_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Backups    []backup  `json:"backups"`
+}
+
+type backup struct {
+	// ResourceID is ID of the backed up instance
+	ResourceID string `json:"resource_id"`
+	ID         string `json:"id"`
+}
+
+func checkBackupConfig(kind, vaultID string) error {
+	switch kind {
+	case "", backupImage:
+	case backupCBR:
+		if vaultID == "" {
+			return fmt.Errorf("CBR vault ID is required for `%s` backup", backupCBR)
+		}
+	default:
+		return fmt.Errorf("unknown backup kind `%s`, supported kinds are: %s, %s", kind, backupImage, backupCBR)
+	}
+	return nil
+}
+
+func (d *Driver) backupManifestPath() string {
+	if d.BaseDriver == nil || d.StorePath == "" {
+		return ""
+	}
+	return filepath.Join(d.StorePath, backupsDirName, d.MachineName+".json")
+}
+
+// saveBackupRecord appends the record to machine backup manifest
+func (d *Driver) saveBackupRecord(record *backupRecord) error {
+	path := d.backupManifestPath()
+	if path == "" {
+		return nil
+	}
+	var records []*backupRecord
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &records); err != nil {
+			return fmt.Errorf("error parsing backup manifest %s: %s", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	records = append(records, record)
+	if data, err = json.MarshalIndent(records, "", "  "); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// backupBeforeRemove backs up instance volumes if configured, backups created are recorded even if backup fails
+func (d *Driver) backupBeforeRemove() error {
+	if d.BackupOnRemove == "" || d.InstanceID == "" {
+		return nil
+	}
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+	status, err := d.getInstanceStatus()
+	if err != nil {
+		if _, ok := err.(*InstanceDeletedError); ok {
+			log.Warnf("Instance `%s` doesn't exist, skipping backup", d.InstanceID)
+			return nil
+		}
+		return err
+	}
+	record := &backupRecord{
+		Machine:    d.MachineName,
+		InstanceID: d.InstanceID,
+		Kind:       d.BackupOnRemove,
+		CreatedAt:  time.Now().UTC(),
+	}
+	switch d.BackupOnRemove {
+	case backupImage:
+		record.VaultID = d.BackupVaultID
+		err = d.backupToImage(record, len(status.VolumesAttached)-1)
+	case backupCBR:
+		record.VaultID = d.BackupVaultID
+		err = d.backupToVault(record)
+	default:
+		err = checkBackupConfig(d.BackupOnRemove, d.BackupVaultID)
+	}
+	if len(record.Backups) > 0 {
+		if saveErr := d.saveBackupRecord(record); saveErr != nil {
+			log.Warnf("Failed to save backup manifest: %s", saveErr)
+		} else {
+			log.Infof("Backups of machine `%s` are recorded in %s", d.MachineName, d.backupManifestPath())
+		}
+	}
+	return err
+}
+
+// backupToImage creates IMS image of the instance, which is kept when instance volumes are deleted.
+// Full-ECS image of all instance disks is created if CBR vault is set, otherwise image of the system disk,
+// which is refused for instance with data volumes unless backup is forced.
+func (d *Driver) backupToImage(record *backupRecord, dataVolumes int) error {
+	if d.BackupVaultID == "" && dataVolumes > 0 {
+		err := fmt.Errorf("image of the system disk doesn't include %d data volume(s) of instance `%s`, "+
+			"CBR vault is required to back them up", dataVolumes, d.InstanceID)
+		if !d.BackupForce {
+			return err
+		}
+		log.Warnf("%s, data volumes are removed without backup", err)
+	}
+	opts := &ImageOpts{
+		Name:        fmt.Sprintf("%s-%s", d.MachineName, record.CreatedAt.Format("20060102150405")),
+		Description: fmt.Sprintf("Backup of machine `%s` taken before removal", d.MachineName),
+		Type:        ImageTypeSystem,
+	}
+	if d.BackupVaultID != "" {
+		opts.Type = ImageTypeWhole
+		opts.VaultID = d.BackupVaultID
+	}
+	imageID, err := d.CreateImage(opts)
+	if err != nil {
+		return err
+	}
+	record.Backups = append(record.Backups, backup{ResourceID: d.InstanceID, ID: imageID})
+	return nil
+}
+
+// newCBRClient creates client of CBR API found in the catalog, the SDK has no constructor for it
+func newCBRClient(client *golangsdk.ProviderClient, eo golangsdk.EndpointOpts) (*golangsdk.ServiceClient, error) {
+	eo.ApplyDefaults(serviceCBR)
+	endpoint, err := client.EndpointLocator(eo)
+	if err != nil {
+		return nil, err
+	}
+	return &golangsdk.ServiceClient{
+		ProviderClient: client,
+		Endpoint:       endpoint,
+		ResourceBase:   endpoint,
+		Type:           serviceCBR,
+	}, nil
+}
+
+type cbrResource struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+const cbrServerType = "OS::Nova::Server"
+
+// backupToVault creates CBR checkpoint of the instance, adding the instance to the vault if needed
+func (d *Driver) backupToVault(record *backupRecord) error {
+	cbr, err := d.catalogClient(serviceCBR, newCBRClient)
+	if err != nil {
+		return err
+	}
+	vault := struct {
+		Vault struct {
+			Resources []cbrResource `json:"resources"`
+		} `json:"vault"`
+	}{}
+	if _, err := cbr.Get(cbr.ServiceURL("vaults", d.BackupVaultID), &vault, nil); err != nil {
+		return fmt.Errorf("error getting vault `%s`: %s", d.BackupVaultID, err)
+	}
+	resource := cbrResource{ID: d.InstanceID, Type: cbrServerType}
+	associated := false
+	for _, r := range vault.Vault.Resources {
+		associated = associated || r.ID == d.InstanceID
+	}
+	if !associated {
+		body := map[string]interface{}{"resources": []cbrResource{resource}}
+		if _, err := cbr.Post(cbr.ServiceURL("vaults", d.BackupVaultID, "addresources"), body, nil,
+			&golangsdk.RequestOpts{OkCodes: []int{200}}); err != nil {
+			return fmt.Errorf("error adding instance `%s` to vault `%s`: %s", d.InstanceID, d.BackupVaultID, err)
+		}
+	}
+
+	body := map[string]interface{}{
+		"checkpoint": map[string]interface{}{
+			"vault_id": d.BackupVaultID,
+			"parameters": map[string]interface{}{
+				"resource_details": []cbrResource{resource},
+				"description":      fmt.Sprintf("Backup of machine `%s` taken before removal", d.MachineName),
+			},
+		},
+	}
+	checkpoint := struct {
+		Checkpoint struct {
+			ID string `json:"id"`
+		} `json:"checkpoint"`
+	}{}
+	if _, err := cbr.Post(cbr.ServiceURL("checkpoints"), body, &checkpoint,
+		&golangsdk.RequestOpts{OkCodes: []int{200}}); err != nil {
+		return fmt.Errorf("error creating backup of instance `%s`: %s", d.InstanceID, err)
+	}
+	checkpointID := checkpoint.Checkpoint.ID
+	log.Infof("Creating backup of instance `%s` in checkpoint `%s`", d.InstanceID, checkpointID)
+
+	return d.waitFor(phaseBackup, fmt.Sprintf("checkpoint `%s`", checkpointID), func() (bool, string, error) {
+		list := struct {
+			Backups []struct {
+				ID         string `json:"id"`
+				ResourceID string `json:"resource_id"`
+				Status     string `json:"status"`
+			} `json:"backups"`
+		}{}
+		if _, err := cbr.Get(cbr.ServiceURL("backups")+"?checkpoint_id="+checkpointID, &list, nil); err != nil {
+			return false, "", err
+		}
+		record.Backups = nil
+		done := len(list.Backups) > 0
+		status := ""
+		for _, b := range list.Backups {
+			record.Backups = append(record.Backups, backup{ResourceID: b.ResourceID, ID: b.ID})
+			status = b.Status
+			if b.Status == "error" {
+				return false, status, fmt.Errorf("backup `%s` is in error state", b.ID)
+			}
+			done = done && b.Status == "available"
+		}
+		return done, status, nil
+	})
+}
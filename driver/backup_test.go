@@ -0,0 +1,242 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBackupDriver(t *testing.T, iam *fakeIAM, kind string) *Driver {
+	storePath, err := ioutil.TempDir("", "otc-backup")
+	require.NoError(t, err)
+	driver := newTLSDriver(iam)
+	driver.StorePath = storePath
+	driver.InstanceID = "instance"
+	driver.PollIntervals = []string{"10ms"}
+	driver.BackupOnRemove = kind
+	driver.BackupVaultID = "vault"
+	return driver
+}
+
+func readBackupManifest(t *testing.T, driver *Driver) []*backupRecord {
+	data, err := ioutil.ReadFile(driver.backupManifestPath())
+	require.NoError(t, err)
+	var records []*backupRecord
+	require.NoError(t, json.Unmarshal(data, &records))
+	return records
+}
+
+func TestDriver_BackupImage(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newBackupDriver(t, iam, backupImage)
+	defer func() { _ = os.RemoveAll(driver.StorePath) }()
+	driver.BackupVaultID = ""
+	require.NoError(t, driver.backupBeforeRemove())
+	driver.BackupVaultID = "vault"
+	require.NoError(t, driver.backupBeforeRemove())
+	assert.Equal(t, []string{"image instance", "image instance"}, iam.Actions())
+	require.Len(t, iam.ims.images, 2)
+	assert.Equal(t, ImageTypeSystem, iam.ims.images[0].Type)
+	assert.Equal(t, ImageTypeWhole, iam.ims.images[1].Type)
+
+	records := readBackupManifest(t, driver)
+	require.Len(t, records, 2)
+	assert.Equal(t, instanceName, records[0].Machine)
+	assert.Equal(t, "instance", records[0].InstanceID)
+	assert.Equal(t, backupImage, records[0].Kind)
+	assert.Equal(t, []backup{{ResourceID: "instance", ID: "image-1"}}, records[0].Backups)
+	assert.Equal(t, "vault", records[1].VaultID)
+	assert.Equal(t, []backup{{ResourceID: "instance", ID: "image-2"}}, records[1].Backups)
+}
+
+func TestDriver_BackupImageDataVolumes(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	iam.compute.setServerStatus("instance", &instanceStatus{
+		Status:          "ACTIVE",
+		PowerState:      powerStateRunning,
+		VolumesAttached: []attachedVolume{{ID: "system-volume"}, {ID: "data-volume"}},
+	})
+
+	// data volumes are not included into image of the system disk
+	driver := newBackupDriver(t, iam, backupImage)
+	defer func() { _ = os.RemoveAll(driver.StorePath) }()
+	driver.BackupVaultID = ""
+	err := driver.Remove()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "machine is not removed")
+	assert.Contains(t, err.Error(), "1 data volume(s)")
+	assert.Empty(t, iam.Actions())
+
+	driver.BackupForce = true
+	require.NoError(t, driver.backupBeforeRemove())
+	assert.Equal(t, []string{"image instance"}, iam.Actions())
+	assert.Equal(t, ImageTypeSystem, iam.ims.images[0].Type)
+
+	// whole image includes data volumes
+	driver.BackupForce = false
+	driver.BackupVaultID = "vault"
+	require.NoError(t, driver.backupBeforeRemove())
+	assert.Equal(t, ImageTypeWhole, iam.ims.images[1].Type)
+}
+
+func TestDriver_BackupSurvivesRemoval(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newBackupDriver(t, iam, backupImage)
+	defer func() { _ = os.RemoveAll(driver.StorePath) }()
+	// network of the fake isn't available, the instance is deleted anyway
+	if err := driver.Remove(); err != nil {
+		assert.NotContains(t, err.Error(), "machine is not removed")
+	}
+	assert.Equal(t, []string{"image instance", "delete"}, iam.Actions())
+	_, err := driver.getInstanceStatus()
+	assert.IsType(t, &InstanceDeletedError{}, err)
+
+	records := readBackupManifest(t, driver)
+	require.Len(t, records, 1)
+	// image is not removed with the instance and its volumes
+	imageID, err := driver.latestMachineImage(instanceName)
+	require.NoError(t, err)
+	assert.Equal(t, records[0].Backups[0].ID, imageID)
+}
+
+func TestDriver_BackupCBR(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newBackupDriver(t, iam, backupCBR)
+	defer func() { _ = os.RemoveAll(driver.StorePath) }()
+	require.NoError(t, driver.backupBeforeRemove())
+	require.NoError(t, driver.backupBeforeRemove())
+	assert.Equal(t, []string{"addresources", "checkpoint instance", "checkpoint instance"}, iam.Actions())
+
+	records := readBackupManifest(t, driver)
+	require.Len(t, records, 2)
+	assert.Equal(t, "vault", records[0].VaultID)
+	assert.Equal(t, []backup{{ResourceID: "instance", ID: "backup-instance"}}, records[0].Backups)
+}
+
+func TestDriver_BackupDeletedInstance(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	iam.compute.setServerStatus("instance", nil)
+
+	driver := newBackupDriver(t, iam, backupImage)
+	defer func() { _ = os.RemoveAll(driver.StorePath) }()
+	require.NoError(t, driver.backupBeforeRemove())
+	assert.Empty(t, iam.Actions())
+	assert.NoFileExists(t, driver.backupManifestPath())
+}
+
+func TestDriver_RemoveBackupFailure(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	iam.ims.failJobs = true
+
+	driver := newBackupDriver(t, iam, backupImage)
+	defer func() { _ = os.RemoveAll(driver.StorePath) }()
+	err := driver.Remove()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "machine is not removed")
+	assert.Equal(t, []string{"image instance"}, iam.Actions())
+	assert.NoFileExists(t, driver.backupManifestPath())
+
+	driver.BackupForce = true
+	err = driver.Remove()
+	if err != nil {
+		assert.NotContains(t, err.Error(), "machine is not removed")
+	}
+	assert.Equal(t, []string{"image instance", "image instance", "delete"}, iam.Actions())
+}
+
+func TestCheckBackupConfig(t *testing.T) {
+	assert.NoError(t, checkBackupConfig("", ""))
+	assert.NoError(t, checkBackupConfig(backupImage, ""))
+	assert.NoError(t, checkBackupConfig(backupCBR, "vault"))
+	assert.Error(t, checkBackupConfig(backupCBR, ""))
+	assert.Error(t, checkBackupConfig("tape", ""))
+}
+
+// fakeCBR serves vault `vault` accepting only tokens of fake IAM, checkpoint of the vault contains
+// a backup of each requested server
+type fakeCBR struct {
+	iam *fakeIAM
+
+	mu sync.Mutex
+	// resources are IDs of servers associated with the vault
+	resources []string
+}
+
+func (s *fakeCBR) handle(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := s.iam.requestProject(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := fmt.Sprintf("/cbr/v3/%s/", projectID)
+	w.Header().Set("Content-Type", "application/json")
+	resources := func() []map[string]string {
+		list := []map[string]string{}
+		for _, id := range s.resources {
+			list = append(list, map[string]string{"id": id, "type": "OS::Nova::Server"})
+		}
+		return list
+	}
+	switch path := strings.TrimPrefix(r.URL.Path, prefix); {
+	case r.Method == http.MethodGet && path == "vaults/vault":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"vault": map[string]interface{}{"id": "vault", "resources": resources()},
+		})
+	case r.Method == http.MethodPost && path == "vaults/vault/addresources":
+		body := struct {
+			Resources []map[string]string `json:"resources"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		for _, resource := range body.Resources {
+			s.resources = append(s.resources, resource["id"])
+		}
+		s.iam.recordAction("addresources")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"add_resource_ids": s.resources})
+	case r.Method == http.MethodPost && path == "checkpoints":
+		body := struct {
+			Checkpoint struct {
+				VaultID    string `json:"vault_id"`
+				Parameters struct {
+					ResourceDetails []map[string]string `json:"resource_details"`
+				} `json:"parameters"`
+			} `json:"checkpoint"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		details := body.Checkpoint.Parameters.ResourceDetails
+		if body.Checkpoint.VaultID != "vault" || len(details) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.iam.recordAction("checkpoint " + details[0]["id"])
+		_, _ = fmt.Fprintf(w, `{"checkpoint": {"id": "checkpoint-%s", "status": "protecting"}}`, details[0]["id"])
+	case r.Method == http.MethodGet && path == "backups":
+		serverID := strings.TrimPrefix(r.URL.Query().Get("checkpoint_id"), "checkpoint-")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"backups": []map[string]string{
+				{"id": "backup-" + serverID, "resource_id": serverID, "status": "available"},
+			},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
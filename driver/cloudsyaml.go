@@ -0,0 +1,164 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/yaml.v2"
+)
+
+// cloudsPassphraseEnv is environment variable holding passphrase for encrypted clouds files,
+// passphrase is never saved to machine config
+const cloudsPassphraseEnv = "OS_CLOUDS_PASSPHRASE"
+
+// encryptedCloudsYAML loads clouds.yaml and secure.yaml encrypted with OpenPGP,
+// e.g. by `gpg --symmetric` or `gpg --encrypt`. Files are decrypted in memory only.
+// Files which are not set are loaded from default locations as is.
+type encryptedCloudsYAML struct {
+	CloudsFile string
+	SecureFile string
+	KeyFile    string
+	Passphrase []byte
+}
+
+func (d *Driver) encryptedCloudsYAML() *encryptedCloudsYAML {
+	return &encryptedCloudsYAML{
+		CloudsFile: d.EncryptedCloudsFile,
+		SecureFile: d.EncryptedSecureFile,
+		KeyFile:    d.DecryptionKeyFile,
+		Passphrase: []byte(os.Getenv(cloudsPassphraseEnv)),
+	}
+}
+
+// readArmored returns armored block body or data itself if it's not armored
+func readArmored(data []byte) (io.Reader, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		return bytes.NewReader(data), nil
+	}
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return block.Body, nil
+}
+
+func (e *encryptedCloudsYAML) keyRing() (openpgp.EntityList, error) {
+	if e.KeyFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(e.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readArmored(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decryption key %s: %s", e.KeyFile, err)
+	}
+	entities, err := openpgp.ReadKeyRing(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decryption key %s: %s", e.KeyFile, err)
+	}
+	return entities, nil
+}
+
+// decrypt decrypts OpenPGP message using either private key or passphrase
+func (e *encryptedCloudsYAML) decrypt(data []byte) ([]byte, error) {
+	keyRing, err := e.keyRing()
+	if err != nil {
+		return nil, err
+	}
+	if len(keyRing) == 0 && len(e.Passphrase) == 0 {
+		return nil, fmt.Errorf("either decryption key file or %s must be set", cloudsPassphraseEnv)
+	}
+	body, err := readArmored(data)
+	if err != nil {
+		return nil, err
+	}
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		// prompt is called again when passphrase doesn't fit, so it can't loop forever
+		if tried || len(e.Passphrase) == 0 {
+			return nil, fmt.Errorf("decryption key or passphrase doesn't match")
+		}
+		tried = true
+		if symmetric {
+			return e.Passphrase, nil
+		}
+		for _, key := range keys {
+			if err := key.PrivateKey.Decrypt(e.Passphrase); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	md, err := openpgp.ReadMessage(body, keyRing, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	// MDC is checked only after the whole body is read
+	return ioutil.ReadAll(md.UnverifiedBody)
+}
+
+func (e *encryptedCloudsYAML) loadFile(path string) (map[string]clientconfig.Cloud, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := e.decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %s", path, err)
+	}
+	var clouds clientconfig.Clouds
+	if err := yaml.Unmarshal(content, &clouds); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal yaml: %v", err)
+	}
+	return clouds.Clouds, nil
+}
+
+// LoadCloudsYAML loads encrypted clouds.yaml if it's set
+func (e *encryptedCloudsYAML) LoadCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	if e.CloudsFile == "" {
+		return clientconfig.LoadCloudsYAML()
+	}
+	return e.loadFile(e.CloudsFile)
+}
+
+// LoadSecureCloudsYAML loads encrypted secure.yaml if it's set
+func (e *encryptedCloudsYAML) LoadSecureCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	if e.SecureFile == "" {
+		return clientconfig.LoadSecureCloudsYAML()
+	}
+	return e.loadFile(e.SecureFile)
+}
+
+// LoadPublicCloudsYAML loads clouds-public.yaml, it contains no secrets and is never encrypted
+func (e *encryptedCloudsYAML) LoadPublicCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	return clientconfig.LoadPublicCloudsYAML()
+}
+
+// yamlOpts returns custom clouds.yaml loader if encrypted files are used
+func (d *Driver) yamlOpts() clientconfig.YAMLOptsBuilder {
+	if d.EncryptedCloudsFile == "" && d.EncryptedSecureFile == "" {
+		return nil
+	}
+	return d.encryptedCloudsYAML()
+}
+
+// checkEncryptedClouds makes sure encrypted files can be decrypted and contain the cloud
+func (d *Driver) checkEncryptedClouds() error {
+	opts := d.yamlOpts()
+	if opts == nil {
+		return nil
+	}
+	if d.Cloud == "" {
+		return fmt.Errorf("cloud must be set for using encrypted clouds files")
+	}
+	_, err := clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{Cloud: d.Cloud, YAMLOpts: opts})
+	return err
+}
@@ -0,0 +1,157 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+const (
+	testCloudsYAML = `
+clouds:
+  enc:
+    auth:
+      auth_url: https://iam.example.com/v3
+      username: user
+      project_name: eu-de_project
+      user_domain_name: domain
+    region_name: eu-de
+`
+	testSecureYAML = `
+clouds:
+  enc:
+    auth:
+      password: very-secret
+`
+	testPassphrase = "test-passphrase"
+)
+
+func encryptSymmetric(t *testing.T, path, content string) {
+	buf := &bytes.Buffer{}
+	w, err := openpgp.SymmetricallyEncrypt(buf, []byte(testPassphrase), nil, nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+}
+
+// encryptForKey encrypts content for a new key, armored private key is written to `keyPath`
+func encryptForKey(t *testing.T, path, keyPath, content string) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", &packet.Config{DefaultHash: crypto.SHA256})
+	require.NoError(t, err)
+
+	keyBuf := &bytes.Buffer{}
+	keyWriter, err := armor.Encode(keyBuf, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(keyWriter, nil))
+	require.NoError(t, keyWriter.Close())
+	require.NoError(t, ioutil.WriteFile(keyPath, keyBuf.Bytes(), 0600))
+
+	buf := &bytes.Buffer{}
+	w, err := openpgp.Encrypt(buf, []*openpgp.Entity{entity}, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+}
+
+func TestEncryptedCloudsYAML_Passphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clouds")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "clouds.yaml.gpg")
+	encryptSymmetric(t, path, testCloudsYAML)
+
+	loader := &encryptedCloudsYAML{CloudsFile: path, Passphrase: []byte(testPassphrase)}
+	clouds, err := loader.LoadCloudsYAML()
+	require.NoError(t, err)
+	require.Contains(t, clouds, "enc")
+	assert.Equal(t, "user", clouds["enc"].AuthInfo.Username)
+
+	loader.Passphrase = []byte("wrong")
+	_, err = loader.LoadCloudsYAML()
+	assert.Error(t, err)
+
+	loader.Passphrase = nil
+	_, err = loader.LoadCloudsYAML()
+	assert.Error(t, err)
+}
+
+func TestEncryptedCloudsYAML_Key(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clouds")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "clouds.yaml.gpg")
+	keyPath := filepath.Join(dir, "key.asc")
+	encryptForKey(t, path, keyPath, testCloudsYAML)
+
+	loader := &encryptedCloudsYAML{CloudsFile: path, KeyFile: keyPath}
+	clouds, err := loader.LoadCloudsYAML()
+	require.NoError(t, err)
+	assert.Equal(t, "https://iam.example.com/v3", clouds["enc"].AuthInfo.AuthURL)
+
+	// key not matching the file
+	otherPath := filepath.Join(dir, "other.yaml.gpg")
+	encryptForKey(t, otherPath, filepath.Join(dir, "other.asc"), testCloudsYAML)
+	loader.CloudsFile = otherPath
+	_, err = loader.LoadCloudsYAML()
+	assert.Error(t, err)
+}
+
+func TestEncryptedCloudsYAML_Merge(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "clouds")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cloudsPath := filepath.Join(dir, "clouds.yaml.gpg")
+	securePath := filepath.Join(dir, "secure.yaml.gpg")
+	encryptSymmetric(t, cloudsPath, testCloudsYAML)
+	encryptSymmetric(t, securePath, testSecureYAML)
+
+	require.NoError(t, os.Setenv(cloudsPassphraseEnv, testPassphrase))
+	defer func() { _ = os.Unsetenv(cloudsPassphraseEnv) }()
+
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-cloud":                 "enc",
+			"otc-encrypted-clouds-file": cloudsPath,
+			"otc-encrypted-secure-file": securePath,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+
+	cloud, err := clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{Cloud: driver.Cloud, YAMLOpts: driver.yamlOpts()})
+	require.NoError(t, err)
+	assert.Equal(t, "user", cloud.AuthInfo.Username)
+	assert.Equal(t, "very-secret", cloud.AuthInfo.Password)
+	assert.Equal(t, "eu-de", cloud.RegionName)
+
+	// only file paths are saved to machine config, never the passphrase
+	reloaded := reloadDriver(t, driver)
+	assert.Equal(t, cloudsPath, reloaded.EncryptedCloudsFile)
+	assert.Equal(t, securePath, reloaded.EncryptedSecureFile)
+
+	driver = NewDriver(instanceName, storePath)
+	flags.FlagsValues["otc-cloud"] = "not-existing"
+	assert.Error(t, driver.SetConfigFromFlags(flags))
+}
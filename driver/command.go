@@ -0,0 +1,98 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/machine/libmachine/mcnutils"
+)
+
+const commandUsage = `Usage: docker-machine-driver-otc resize [--storage-path PATH] MACHINE FLAVOR
+
+Commands:
+  resize    change flavor of existing machine instance`
+
+// RunCommand runs driver command outside of docker-machine plugin server
+func RunCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(commandUsage)
+	}
+	switch args[0] {
+	case "resize":
+		return resizeCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command `%s`\n\n%s", args[0], commandUsage)
+	}
+}
+
+func defaultStoragePath() string {
+	if path := os.Getenv("MACHINE_STORAGE_PATH"); path != "" {
+		return path
+	}
+	return filepath.Join(mcnutils.GetHomeDir(), ".docker", "machine")
+}
+
+func resizeCommand(args []string) error {
+	flags := flag.NewFlagSet("resize", flag.ContinueOnError)
+	storagePath := flags.String("storage-path", defaultStoragePath(), "docker-machine storage path")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New(commandUsage)
+	}
+	machineName, flavorName := flags.Arg(0), flags.Arg(1)
+
+	config := machineConfig{path: filepath.Join(*storagePath, "machines", machineName, "config.json")}
+	d, err := config.load()
+	if err != nil {
+		return err
+	}
+	if err := d.Resize(flavorName); err != nil {
+		return err
+	}
+	return config.save(d)
+}
+
+// machineConfig is docker-machine host configuration containing driver configuration
+type machineConfig struct {
+	path   string
+	fields map[string]json.RawMessage
+}
+
+func (c *machineConfig) load() (*Driver, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading machine configuration: %s", err)
+	}
+	if err := json.Unmarshal(data, &c.fields); err != nil {
+		return nil, fmt.Errorf("error parsing machine configuration: %s", err)
+	}
+	var name string
+	if err := json.Unmarshal(c.fields["DriverName"], &name); err != nil || name != driverName {
+		return nil, fmt.Errorf("machine is not created by %s driver", driverName)
+	}
+	d := NewDriver("", "")
+	if err := json.Unmarshal(c.fields["Driver"], d); err != nil {
+		return nil, fmt.Errorf("error parsing driver configuration: %s", err)
+	}
+	return d, nil
+}
+
+func (c *machineConfig) save(d *Driver) error {
+	driverData, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	c.fields["Driver"] = driverData
+	data, err := json.MarshalIndent(c.fields, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, data, 0600)
+}
@@ -0,0 +1,241 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Credential stores define where secrets are kept between driver calls
+const (
+	// credentials are saved in machine config.json as is
+	credentialsStoreConfig = "config"
+	// credentials are read from clouds.yaml, nothing is saved
+	credentialsStoreCloud = "cloud"
+	// credentials are read from environment variables, only variable names are saved
+	credentialsStoreEnv = "env"
+	// credentials are saved in OS keyring, only entry name is saved
+	credentialsStoreKeyring = "keyring"
+
+	defaultCredentialsStore = credentialsStoreConfig
+	keyringService          = "docker-machine-otc"
+)
+
+// credentials contains all secrets used for authentication
+type credentials struct {
+	Password      string `json:"password,omitempty"`
+	AccessKey     string `json:"access_key,omitempty"`
+	SecretKey     string `json:"secret_key,omitempty"`
+	Token         string `json:"token,omitempty"`
+	SecurityToken string `json:"security_token,omitempty"`
+	// ProxyAuth is user info of proxy URL
+	ProxyAuth string `json:"proxy_auth,omitempty"`
+}
+
+// defaultCredentialsEnv maps credentials to environment variables used by `env` store
+var defaultCredentialsEnv = map[string]string{
+	"password":       "OS_PASSWORD",
+	"access_key":     "ACCESS_KEY_ID",
+	"secret_key":     "ACCESS_KEY_SECRET",
+	"token":          "OS_TOKEN",
+	"security_token": "OS_SECURITY_TOKEN",
+	"proxy_auth":     "OS_PROXY_AUTH",
+}
+
+var credentialNames = []string{"password", "access_key", "secret_key", "token", "security_token", "proxy_auth"}
+
+// parseCredentialsEnv returns default environment variables of `env` store with overrides
+// in form `<credential>=<variable>` applied
+func parseCredentialsEnv(specs []string) (map[string]string, error) {
+	env := make(map[string]string, len(defaultCredentialsEnv))
+	for name, variable := range defaultCredentialsEnv {
+		env[name] = variable
+	}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid credentials variable `%s`, expected `<credential>=<variable>`", spec)
+		}
+		if _, ok := env[kv[0]]; !ok {
+			return nil, fmt.Errorf("unknown credential `%s`, supported credentials are: %s",
+				kv[0], strings.Join(credentialNames, ", "))
+		}
+		env[kv[0]] = kv[1]
+	}
+	return env, nil
+}
+
+func (d *Driver) credentials() *credentials {
+	_, proxyAuth := splitProxyAuth(d.HTTPProxy)
+	return &credentials{
+		Password:      d.Password,
+		AccessKey:     d.AccessKey,
+		SecretKey:     d.SecretKey,
+		Token:         d.Token,
+		SecurityToken: d.SecurityToken,
+		ProxyAuth:     proxyAuth,
+	}
+}
+
+// setCredentials sets credentials which are not set already
+func (d *Driver) setCredentials(creds *credentials) {
+	if d.Password == "" {
+		d.Password = creds.Password
+	}
+	if d.AccessKey == "" {
+		d.AccessKey = creds.AccessKey
+	}
+	if d.SecretKey == "" {
+		d.SecretKey = creds.SecretKey
+	}
+	if d.Token == "" {
+		d.Token = creds.Token
+	}
+	if d.SecurityToken == "" {
+		d.SecurityToken = creds.SecurityToken
+	}
+	d.HTTPProxy = withProxyAuth(d.HTTPProxy, creds.ProxyAuth)
+}
+
+// keyringEntry returns entry set by user or the machine directory, so machines of different stores don't clash
+func (d *Driver) keyringEntry() string {
+	if d.CredentialsRef != "" {
+		return d.CredentialsRef
+	}
+	return d.ResolveStorePath(".")
+}
+
+func (d *Driver) saveKeyringCredentials() error {
+	data, err := json.Marshal(d.credentials())
+	if err != nil {
+		return err
+	}
+	if err := keyring.Set(keyringService, d.keyringEntry(), string(data)); err != nil {
+		return fmt.Errorf("failed to save credentials to keyring: %s", err)
+	}
+	return nil
+}
+
+func (d *Driver) loadKeyringCredentials() error {
+	data, err := keyring.Get(keyringService, d.keyringEntry())
+	if err != nil {
+		return fmt.Errorf("failed to load credentials from keyring entry `%s`: %s", d.keyringEntry(), err)
+	}
+	creds := &credentials{}
+	if err := json.Unmarshal([]byte(data), creds); err != nil {
+		return err
+	}
+	d.setCredentials(creds)
+	return nil
+}
+
+func (d *Driver) loadEnvCredentials() {
+	env := d.CredentialsEnv
+	if env == nil {
+		env = defaultCredentialsEnv
+	}
+	d.setCredentials(&credentials{
+		Password:      os.Getenv(env["password"]),
+		AccessKey:     os.Getenv(env["access_key"]),
+		SecretKey:     os.Getenv(env["secret_key"]),
+		Token:         os.Getenv(env["token"]),
+		SecurityToken: os.Getenv(env["security_token"]),
+		ProxyAuth:     os.Getenv(env["proxy_auth"]),
+	})
+}
+
+// checkCredentialsStore validates credentials store and saves credentials to keyring if required
+func (d *Driver) checkCredentialsStore() error {
+	if len(d.CredentialsEnvNames) > 0 && d.CredentialsStore != credentialsStoreEnv {
+		return fmt.Errorf("credentials variables are used only by `%s` credentials store", credentialsStoreEnv)
+	}
+	switch d.CredentialsStore {
+	case credentialsStoreConfig:
+	case credentialsStoreCloud:
+		if d.Cloud == "" {
+			return fmt.Errorf("`%s` credentials store requires cloud to be set", credentialsStoreCloud)
+		}
+		if _, auth := splitProxyAuth(d.HTTPProxy); auth != "" {
+			return fmt.Errorf("proxy credentials can't be kept in `%s` credentials store", credentialsStoreCloud)
+		}
+	case credentialsStoreEnv:
+		env, err := parseCredentialsEnv(d.CredentialsEnvNames)
+		if err != nil {
+			return err
+		}
+		d.CredentialsEnv = env
+	case credentialsStoreKeyring:
+		return d.saveKeyringCredentials()
+	default:
+		return fmt.Errorf("unsupported credentials store `%s`", d.CredentialsStore)
+	}
+	return nil
+}
+
+// migrateCredentialsEnv is set to credentials store which secrets of configs created
+// before credentials stores were introduced are moved to
+const migrateCredentialsEnv = "OTC_MIGRATE_CREDENTIALS"
+
+// migrateCredentials sets store of config created before credentials stores were introduced,
+// secrets are kept in config as by default unless moving them to keyring is requested explicitly
+func (d *Driver) migrateCredentials() error {
+	switch target := os.Getenv(migrateCredentialsEnv); target {
+	case "", credentialsStoreConfig:
+		d.CredentialsStore = credentialsStoreConfig
+	case credentialsStoreKeyring:
+		if err := d.saveKeyringCredentials(); err != nil {
+			return err
+		}
+		d.CredentialsStore = credentialsStoreKeyring
+	default:
+		return fmt.Errorf("credentials can't be migrated to `%s` store, supported stores are: %s, %s",
+			target, credentialsStoreConfig, credentialsStoreKeyring)
+	}
+	return nil
+}
+
+// resolveCredentials loads secrets from credentials store
+func (d *Driver) resolveCredentials() error {
+	switch d.CredentialsStore {
+	case "":
+		return d.migrateCredentials()
+	case credentialsStoreEnv:
+		d.loadEnvCredentials()
+	case credentialsStoreKeyring:
+		if *d.credentials() != (credentials{}) {
+			return nil
+		}
+		return d.loadKeyringCredentials()
+	}
+	return nil
+}
+
+func (d *Driver) deleteKeyringCredentials() error {
+	if d.CredentialsStore != credentialsStoreKeyring || d.CredentialsRef != "" {
+		// shared entries are managed by user
+		return nil
+	}
+	err := keyring.Delete(keyringService, d.keyringEntry())
+	if err == keyring.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// MarshalJSON omits secrets, including proxy credentials, from config unless they are stored in config
+func (d *Driver) MarshalJSON() ([]byte, error) {
+	type plainDriver Driver
+	cfg := plainDriver(*d)
+	if d.CredentialsStore != credentialsStoreConfig && d.CredentialsStore != "" {
+		cfg.Password = ""
+		cfg.AccessKey = ""
+		cfg.SecretKey = ""
+		cfg.Token = ""
+		cfg.SecurityToken = ""
+		cfg.HTTPProxy, _ = splitProxyAuth(d.HTTPProxy)
+	}
+	return json.Marshal(cfg)
+}
@@ -0,0 +1,209 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	testPassword  = "my-secret-password"
+	testSecretKey = "my-secret-key"
+)
+
+func configFromFlags(t *testing.T, storePath string, driverFlags map[string]interface{}) *Driver {
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: driverFlags,
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	return driver
+}
+
+// reloadDriver simulates loading of saved machine config by another driver process
+func reloadDriver(t *testing.T, driver *Driver) *Driver {
+	data, err := json.Marshal(driver)
+	require.NoError(t, err)
+	loaded := NewDriver("", "")
+	require.NoError(t, json.Unmarshal(data, loaded))
+	return loaded
+}
+
+func TestDriver_CredentialsConfigStore(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := configFromFlags(t, storePath, map[string]interface{}{
+		"otc-username": "user",
+		"otc-password": testPassword,
+	})
+	assert.Equal(t, credentialsStoreConfig, driver.CredentialsStore)
+	loaded := reloadDriver(t, driver)
+	assert.Equal(t, testPassword, loaded.Password)
+}
+
+func TestDriver_CredentialsCloudStore(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := configFromFlags(t, storePath, map[string]interface{}{
+		"otc-cloud":             "otc",
+		"otc-password":          testPassword,
+		"otc-credentials-store": credentialsStoreCloud,
+	})
+	data, err := json.Marshal(driver)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), testPassword)
+	assert.Equal(t, testPassword, driver.Password, "credentials are kept in memory")
+
+	invalid := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-username":          "user",
+			"otc-password":          testPassword,
+			"otc-credentials-store": credentialsStoreCloud,
+		},
+		CreateFlags: invalid.GetCreateFlags(),
+	}
+	assert.Error(t, invalid.SetConfigFromFlags(flags))
+}
+
+func TestDriver_CredentialsEnvStore(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	require.NoError(t, os.Setenv("ACCESS_KEY_ID", "AK"))
+	require.NoError(t, os.Setenv("ACCESS_KEY_SECRET", testSecretKey))
+	defer func() {
+		_ = os.Unsetenv("ACCESS_KEY_ID")
+		_ = os.Unsetenv("ACCESS_KEY_SECRET")
+	}()
+
+	driver := configFromFlags(t, storePath, map[string]interface{}{
+		"otc-access-key-id":     "AK",
+		"otc-access-key-key":    testSecretKey,
+		"otc-credentials-store": credentialsStoreEnv,
+	})
+	data, err := json.Marshal(driver)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), testSecretKey)
+	assert.Contains(t, string(data), "ACCESS_KEY_SECRET")
+
+	loaded := reloadDriver(t, driver)
+	assert.Empty(t, loaded.SecretKey)
+	require.NoError(t, loaded.resolveCredentials())
+	assert.Equal(t, "AK", loaded.AccessKey)
+	assert.Equal(t, testSecretKey, loaded.SecretKey)
+}
+
+func TestDriver_CredentialsEnvNames(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	require.NoError(t, os.Setenv("ACCESS_KEY_ID", "AK"))
+	require.NoError(t, os.Setenv("DMD_TEST_SECRET", testSecretKey))
+	defer func() {
+		_ = os.Unsetenv("ACCESS_KEY_ID")
+		_ = os.Unsetenv("DMD_TEST_SECRET")
+	}()
+
+	driver := configFromFlags(t, storePath, map[string]interface{}{
+		"otc-access-key-id":     "AK",
+		"otc-access-key-key":    testSecretKey,
+		"otc-credentials-store": credentialsStoreEnv,
+		"otc-credentials-env":   []string{"secret_key=DMD_TEST_SECRET"},
+	})
+	assert.Equal(t, "ACCESS_KEY_ID", driver.CredentialsEnv["access_key"])
+	assert.Equal(t, "DMD_TEST_SECRET", driver.CredentialsEnv["secret_key"])
+	// defaults are kept intact
+	assert.Equal(t, "ACCESS_KEY_SECRET", defaultCredentialsEnv["secret_key"])
+
+	loaded := reloadDriver(t, driver)
+	require.NoError(t, loaded.resolveCredentials())
+	assert.Equal(t, "AK", loaded.AccessKey)
+	assert.Equal(t, testSecretKey, loaded.SecretKey)
+
+	for _, specs := range [][]string{{"secret_key"}, {"secret_key="}, {"api_key=API_KEY"}} {
+		_, err := parseCredentialsEnv(specs)
+		assert.Error(t, err, "%v", specs)
+	}
+
+	driver = NewDriver(instanceName, storePath)
+	driver.CredentialsStore = credentialsStoreConfig
+	driver.CredentialsEnvNames = []string{"secret_key=DMD_TEST_SECRET"}
+	assert.Error(t, driver.checkCredentialsStore())
+}
+
+func TestDriver_CredentialsKeyringStore(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	keyring.MockInit()
+
+	driver := configFromFlags(t, storePath, map[string]interface{}{
+		"otc-username":          "user",
+		"otc-password":          testPassword,
+		"otc-credentials-store": credentialsStoreKeyring,
+	})
+	data, err := json.Marshal(driver)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), testPassword)
+
+	loaded := reloadDriver(t, driver)
+	require.NoError(t, loaded.resolveCredentials())
+	assert.Equal(t, testPassword, loaded.Password)
+
+	require.NoError(t, loaded.deleteKeyringCredentials())
+	_, err = keyring.Get(keyringService, loaded.keyringEntry())
+	assert.Equal(t, keyring.ErrNotFound, err)
+}
+
+func TestDriver_KeyringEntry(t *testing.T) {
+	// machines of the same name in different stores use different entries
+	first := NewDriver(instanceName, "/first")
+	second := NewDriver(instanceName, "/second")
+	assert.NotEqual(t, first.keyringEntry(), second.keyringEntry())
+
+	first.CredentialsRef = "shared"
+	assert.Equal(t, "shared", first.keyringEntry())
+}
+
+func TestDriver_CredentialsMigration(t *testing.T) {
+	keyring.MockInit()
+	legacyConfig := `{"MachineName": "legacy", "StorePath": "/store", "username": "user", "password": "` + testPassword + `"}`
+
+	// secrets are kept in config by default
+	driver := NewDriver("", "")
+	require.NoError(t, json.Unmarshal([]byte(legacyConfig), driver))
+	require.NoError(t, driver.resolveCredentials())
+	assert.Equal(t, credentialsStoreConfig, driver.CredentialsStore)
+	data, err := json.Marshal(driver)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), testPassword)
+	_, err = keyring.Get(keyringService, driver.keyringEntry())
+	assert.Equal(t, keyring.ErrNotFound, err)
+
+	defer os.Unsetenv(migrateCredentialsEnv)
+	require.NoError(t, os.Setenv(migrateCredentialsEnv, credentialsStoreKeyring))
+	driver = NewDriver("", "")
+	require.NoError(t, json.Unmarshal([]byte(legacyConfig), driver))
+	require.NoError(t, driver.resolveCredentials())
+	assert.Equal(t, credentialsStoreKeyring, driver.CredentialsStore)
+
+	data, err = json.Marshal(driver)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), testPassword)
+
+	loaded := reloadDriver(t, driver)
+	require.NoError(t, loaded.resolveCredentials())
+	assert.Equal(t, testPassword, loaded.Password)
+
+	require.NoError(t, os.Setenv(migrateCredentialsEnv, credentialsStoreEnv))
+	driver = NewDriver("", "")
+	require.NoError(t, json.Unmarshal([]byte(legacyConfig), driver))
+	assert.Error(t, driver.resolveCredentials())
+}
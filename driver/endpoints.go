@@ -0,0 +1,221 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+)
+
+// Services which endpoints can be overridden, each of them must be registered by a client
+const (
+	serviceCompute = "compute"
+	serviceVPC     = "vpc"
+	serviceEIP     = "eip"
+	serviceIMS     = "ims"
+	serviceECS     = "ecs"
+	serviceCBR     = "cbr"
+)
+
+var overridableServices = []string{serviceCompute, serviceVPC, serviceEIP, serviceIMS, serviceECS, serviceCBR}
+
+// eipResources are served by VPC client, but can be sent to separate EIP endpoint
+var eipResources = []string{"publicips", "bandwidths"}
+
+var projectIDPlaceholders = []string{"%(project_id)s", "%(tenant_id)s"}
+
+func expandProjectID(endpoint, projectID string) string {
+	for _, placeholder := range projectIDPlaceholders {
+		endpoint = strings.Replace(endpoint, placeholder, projectID, -1)
+	}
+	return endpoint
+}
+
+// parseEndpointOverride parses override in form `<service>=<url>`
+func parseEndpointOverride(spec string) (service, endpoint string, err error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return "", "", fmt.Errorf("invalid endpoint override `%s`, expected `<service>=<url>`", spec)
+	}
+	service, endpoint = kv[0], kv[1]
+	supported := false
+	for _, s := range overridableServices {
+		supported = supported || s == service
+	}
+	if !supported {
+		return "", "", fmt.Errorf("endpoint of `%s` can't be overridden, supported services are: %s",
+			service, strings.Join(overridableServices, ", "))
+	}
+	u, err := url.Parse(expandProjectID(endpoint, "project"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", fmt.Errorf("invalid `%s` endpoint override URL `%s`", service, endpoint)
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	return service, endpoint, nil
+}
+
+func (d *Driver) endpointOverrides() (map[string]string, error) {
+	overrides := make(map[string]string, len(d.EndpointOverrides))
+	for _, spec := range d.EndpointOverrides {
+		service, endpoint, err := parseEndpointOverride(spec)
+		if err != nil {
+			return nil, err
+		}
+		overrides[service] = endpoint
+	}
+	return overrides, nil
+}
+
+type rewriteRule struct {
+	from string
+	to   string
+}
+
+// endpointRewriter sends requests for catalog endpoints to overridden ones
+type endpointRewriter struct {
+	base      http.RoundTripper
+	overrides map[string]string
+	// signOpts are used to sign rewritten AK/SK requests again
+	signOpts golangsdk.SignOptions
+
+	mu sync.RWMutex
+	// rules are sorted by prefix length, so the most specific rule is applied first
+	rules []rewriteRule
+}
+
+func newEndpointRewriter(base http.RoundTripper, overrides map[string]string, signOpts golangsdk.SignOptions) *endpointRewriter {
+	return &endpointRewriter{base: base, overrides: overrides, signOpts: signOpts}
+}
+
+func (e *endpointRewriter) addRule(from, to string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, rule := range e.rules {
+		if rule.from == from {
+			e.rules[i].to = to
+			return
+		}
+	}
+	e.rules = append(e.rules, rewriteRule{from: from, to: to})
+	sort.Slice(e.rules, func(i, j int) bool {
+		return len(e.rules[i].from) > len(e.rules[j].from)
+	})
+}
+
+// register maps catalog endpoint of the service client to the overridden one
+func (e *endpointRewriter) register(service string, client *golangsdk.ServiceClient) {
+	if client == nil {
+		return
+	}
+	catalog := client.ResourceBaseURL()
+	expand := func(endpoint string) string {
+		return expandProjectID(endpoint, client.ProjectID)
+	}
+	if endpoint, ok := e.overrides[service]; ok {
+		log.Debugf("Using %s endpoint %s instead of %s", service, expand(endpoint), catalog)
+		e.addRule(catalog, expand(endpoint))
+	}
+	if endpoint, ok := e.overrides[serviceEIP]; ok && service == serviceVPC {
+		for _, resource := range eipResources {
+			e.addRule(catalog+resource, expand(endpoint)+resource)
+		}
+	}
+}
+
+func (e *endpointRewriter) rewrite(rawURL string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, rule := range e.rules {
+		if strings.HasPrefix(rawURL, rule.from) {
+			return rule.to + strings.TrimPrefix(rawURL, rule.from), true
+		}
+	}
+	return "", false
+}
+
+func (e *endpointRewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, ok := e.rewrite(req.URL.String())
+	if !ok {
+		return e.base.RoundTrip(req)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cloneRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+	r.URL = u
+	r.Host = u.Host
+	if isSignedRequest(r) {
+		// host is one of signed headers
+		r.Header.Set("Host", u.Host)
+		golangsdk.ReSign(r, e.signOpts)
+	}
+	return e.base.RoundTrip(r)
+}
+
+// useServiceClients makes crutch-house client use clients of the driver instead of authenticating on its own:
+// the client type is not exported, so its exported fields are set by name
+func useServiceClients(client services.Client, fields map[string]interface{}) error {
+	v := reflect.ValueOf(client)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can't set service clients of %T", client)
+	}
+	for name, value := range fields {
+		f := v.Elem().FieldByName(name)
+		if !f.IsValid() {
+			return fmt.Errorf("field %s is not found in %T", name, client)
+		}
+		if !f.CanSet() || !reflect.TypeOf(value).AssignableTo(f.Type()) {
+			return fmt.Errorf("field %s of %T can't be set to %T", name, client, value)
+		}
+		f.Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+// registerEndpoint applies endpoint override to the service client
+func (d *Driver) registerEndpoint(service string, client *golangsdk.ServiceClient) {
+	if d.endpoints != nil {
+		d.endpoints.register(service, client)
+	}
+}
+
+func (d *Driver) endpointOpts() golangsdk.EndpointOpts {
+	region := d.Region
+	if region == "" {
+		region = defaultRegion
+	}
+	return golangsdk.EndpointOpts{
+		Region:       region,
+		Availability: golangsdk.Availability(clientconfig.GetEndpointType(d.EndpointType)),
+	}
+}
+
+type newClientFunc func(*golangsdk.ProviderClient, golangsdk.EndpointOpts) (*golangsdk.ServiceClient, error)
+
+// catalogClient creates client of the service not initialized by crutch-house client using provider of the driver
+func (d *Driver) catalogClient(service string, newClient newClientFunc) (*golangsdk.ServiceClient, error) {
+	if err := d.Authenticate(); err != nil {
+		return nil, err
+	}
+	sc, err := newClient(d.provider, d.endpointOpts())
+	if err != nil {
+		return nil, fmt.Errorf("error initializing %s client: %s", service, err)
+	}
+	// override replaces catalog endpoint rather than resource base, so requests to other API versions are rewritten too
+	d.registerEndpoint(service, &golangsdk.ServiceClient{ProviderClient: sc.ProviderClient, Endpoint: sc.Endpoint})
+	return sc, nil
+}
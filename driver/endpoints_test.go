@@ -0,0 +1,213 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGateway is a local stand-in for internal API gateway, it accepts tokens issued by fake IAM
+type fakeGateway struct {
+	*httptest.Server
+	iam *fakeIAM
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func newFakeGateway(iam *fakeIAM) *fakeGateway {
+	gw := &fakeGateway{iam: iam}
+	gw.Server = httptest.NewServer(http.HandlerFunc(gw.handle))
+	return gw
+}
+
+func (gw *fakeGateway) Paths() []string {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return append([]string(nil), gw.paths...)
+}
+
+func (gw *fakeGateway) handle(w http.ResponseWriter, r *http.Request) {
+	gw.mu.Lock()
+	gw.paths = append(gw.paths, r.URL.Path)
+	gw.mu.Unlock()
+
+	projectID, ok := gw.iam.requestProject(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	prefix := "/gateway/" + projectID + "/servers/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"server": map[string]string{
+			"id":     strings.TrimPrefix(r.URL.Path, prefix),
+			"status": "ACTIVE",
+		},
+	})
+}
+
+func TestDriver_EndpointOverride(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	gw := newFakeGateway(iam)
+	defer gw.Close()
+
+	driver := newTLSDriver(iam)
+	driver.EndpointOverrides = []string{"compute=" + gw.URL + "/gateway/%(project_id)s"}
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+	server, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Equal(t, "ACTIVE", server.Status)
+	assert.Equal(t, []string{"/gateway/" + fakeProjectID + "/servers/instance"}, gw.Paths())
+}
+
+func TestDriver_EndpointOverrideAKSK(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	gw := newFakeGateway(iam)
+	defer gw.Close()
+
+	driver := newIAMDriver(iam)
+	driver.AccessKey = fakeAccessKey
+	driver.SecretKey = fakeSecretKey
+	driver.EndpointOverrides = []string{"compute=" + gw.URL + "/gateway/" + fakeProjectID}
+	require.NoError(t, driver.initCompute())
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Len(t, gw.Paths(), 1)
+}
+
+func TestDriver_EndpointOverrideServices(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newTLSDriver(iam)
+	for _, service := range overridableServices {
+		driver.EndpointOverrides = append(driver.EndpointOverrides, service+"=https://"+service+".example.com")
+	}
+	require.NoError(t, driver.initCompute())
+	require.NoError(t, driver.initNetwork())
+	_, err := driver.ecsClient()
+	require.NoError(t, err)
+	_, err = driver.imsClient()
+	require.NoError(t, err)
+	_, err = driver.catalogClient(serviceCBR, newCBRClient)
+	require.NoError(t, err)
+
+	// override of service which no client registers would be silently ignored
+	for _, service := range overridableServices {
+		found := false
+		for _, rule := range driver.endpoints.rules {
+			found = found || strings.HasPrefix(rule.to, "https://"+service+".example.com/")
+		}
+		assert.True(t, found, "endpoint of `%s` is not registered", service)
+	}
+}
+
+func TestUseServiceClients(t *testing.T) {
+	provider := fakeProvider("https://ecs.example.com/v2.1/project/")
+	compute := &golangsdk.ServiceClient{ProviderClient: provider}
+	client := services.NewClient(&clientconfig.ClientOpts{})
+	require.NoError(t, useServiceClients(client, map[string]interface{}{
+		"Provider":  provider,
+		"ComputeV2": compute,
+	}))
+	// crutch-house client doesn't authenticate or create compute client if they are set
+	require.NoError(t, client.Authenticate())
+	require.NoError(t, client.InitCompute())
+	v := reflect.ValueOf(client).Elem()
+	assert.Equal(t, provider, v.FieldByName("Provider").Interface())
+	assert.Equal(t, compute, v.FieldByName("ComputeV2").Interface())
+
+	assert.Error(t, useServiceClients(client, map[string]interface{}{"Compute": compute}))
+	assert.Error(t, useServiceClients(client, map[string]interface{}{"VPC": provider}))
+}
+
+func TestEndpointRewriter_EIP(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Host+r.URL.Path)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	overrides := map[string]string{serviceEIP: "http://" + host + "/eip/%(project_id)s/"}
+	rewriter := newEndpointRewriter(http.DefaultTransport, overrides, golangsdk.SignOptions{})
+	vpc := &golangsdk.ServiceClient{
+		ProviderClient: &golangsdk.ProviderClient{ProjectID: "project"},
+		Endpoint:       srv.URL + "/v1/project/",
+	}
+	rewriter.register(serviceVPC, vpc)
+
+	client := &http.Client{Transport: rewriter}
+	for _, resource := range []string{"vpcs", "publicips/id", "bandwidths/id"} {
+		resp, err := client.Get(vpc.Endpoint + resource)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, []string{
+		host + "/v1/project/vpcs",
+		host + "/eip/project/publicips/id",
+		host + "/eip/project/bandwidths/id",
+	}, paths)
+}
+
+func TestDriver_EndpointOverrideConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	invalid := []string{
+		"compute",
+		"compute=",
+		"dns=https://dns.example.com",
+		"evs=https://evs.example.com",
+		"compute=ecs.example.com",
+	}
+	for _, spec := range invalid {
+		driver := NewDriver(instanceName, storePath)
+		flags := &drivers.CheckDriverOptions{
+			FlagsValues: map[string]interface{}{
+				"otc-token":             "token",
+				"otc-endpoint-override": []string{spec},
+			},
+			CreateFlags: driver.GetCreateFlags(),
+		}
+		assert.Error(t, driver.SetConfigFromFlags(flags), spec)
+	}
+
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-token": "token",
+			"otc-endpoint-override": []string{
+				"compute=https://ecs.example.com/v2.1/%(project_id)s",
+				"eip=https://eip.example.com/v1/%(project_id)s/",
+			},
+			"otc-credentials-store": credentialsStoreConfig,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	overrides, err := reloadDriver(t, driver).endpointOverrides()
+	require.NoError(t, err)
+	assert.Equal(t, "https://ecs.example.com/v2.1/%(project_id)s/", overrides[serviceCompute])
+	assert.Equal(t, "https://eip.example.com/v1/%(project_id)s/", overrides[serviceEIP])
+}
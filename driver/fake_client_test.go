@@ -0,0 +1,315 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/extensions/keypairs"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/extensions/secgroups"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/servers"
+	"github.com/huaweicloud/golangsdk/openstack/networking/v1/eips"
+	"github.com/huaweicloud/golangsdk/openstack/networking/v1/subnets"
+	"github.com/huaweicloud/golangsdk/openstack/networking/v1/vpcs"
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+)
+
+// fakeClient is in-memory services.Client implementation for tests not requiring real cloud,
+// calling not implemented method panics
+type fakeClient struct {
+	services.Client
+	// service clients are set by the driver as for crutch-house client
+	Provider  *golangsdk.ProviderClient
+	ComputeV2 *golangsdk.ServiceClient
+	VPC       *golangsdk.ServiceClient
+
+	mu        sync.Mutex
+	keyPairs  map[string]string
+	lastID    int
+	vpcs      map[string]string
+	subnets   map[string]string
+	groups    map[string]string
+	instances map[string]*fakeInstance
+	// eips maps addresses to IDs
+	eips map[string]string
+	// failInstances are names of instances failing to be created
+	failInstances map[string]bool
+}
+
+type fakeInstance struct {
+	Name           string
+	SubnetID       string
+	KeyPairName    string
+	SecurityGroups []string
+	UserData       []byte
+	Tags           []string
+	FloatingIP     string
+	// Polled is set when instance status is requested
+	Polled bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		keyPairs:      make(map[string]string),
+		vpcs:          make(map[string]string),
+		subnets:       make(map[string]string),
+		groups:        make(map[string]string),
+		instances:     make(map[string]*fakeInstance),
+		eips:          make(map[string]string),
+		failInstances: make(map[string]bool),
+	}
+}
+
+// newID returns new resource ID, mu must be held
+func (c *fakeClient) newID(kind string) string {
+	c.lastID++
+	return fmt.Sprintf("%s-%d", kind, c.lastID)
+}
+
+// resources returns number of all existing resources
+func (c *fakeClient) resources() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.keyPairs) + len(c.vpcs) + len(c.subnets) + len(c.groups) + len(c.instances) + len(c.eips)
+}
+
+// fakeProvider returns provider client locating all services at the endpoint without authentication
+func fakeProvider(endpoint string) *golangsdk.ProviderClient {
+	return &golangsdk.ProviderClient{
+		EndpointLocator: func(golangsdk.EndpointOpts) (string, error) {
+			return endpoint, nil
+		},
+	}
+}
+
+func notFound404() error {
+	return golangsdk.ErrDefault404{}
+}
+
+func (c *fakeClient) Authenticate() error { return nil }
+func (c *fakeClient) InitCompute() error  { return nil }
+func (c *fakeClient) InitNetwork() error  { return nil }
+
+func (c *fakeClient) CreateKeyPair(name string, publicKey string) (*keypairs.KeyPair, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keyPairs[name]; ok {
+		return nil, fmt.Errorf("key pair %s already exists", name)
+	}
+	c.keyPairs[name] = publicKey
+	return &keypairs.KeyPair{Name: name, PublicKey: publicKey}, nil
+}
+
+func (c *fakeClient) FindKeyPair(name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keyPairs[name], nil
+}
+
+func (c *fakeClient) GetPublicKey(name string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.keyPairs[name]
+	if !ok {
+		return nil, notFound404()
+	}
+	return []byte(key), nil
+}
+
+func (c *fakeClient) DeleteKeyPair(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keyPairs[name]; !ok {
+		return notFound404()
+	}
+	delete(c.keyPairs, name)
+	return nil
+}
+
+func (c *fakeClient) FindVPC(string) (string, error)                { return "", nil }
+func (c *fakeClient) FindSubnet(string, string) (string, error)     { return "", nil }
+func (c *fakeClient) FindFlavor(name string) (string, error)        { return "flavor-" + name, nil }
+func (c *fakeClient) FindImage(name string) (string, error)         { return "image-" + name, nil }
+func (c *fakeClient) FindSecurityGroups([]string) ([]string, error) { return nil, nil }
+
+func (c *fakeClient) CreateVPC(name string) (*vpcs.Vpc, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.newID("vpc")
+	c.vpcs[id] = name
+	return &vpcs.Vpc{ID: id, Name: name, Status: "CREATING"}, nil
+}
+
+func (c *fakeClient) GetVPCDetails(id string) (*vpcs.Vpc, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.vpcs[id]; !ok {
+		return nil, notFound404()
+	}
+	return &vpcs.Vpc{ID: id, Status: "OK"}, nil
+}
+
+func (c *fakeClient) DeleteVPC(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.vpcs[id]; !ok {
+		return notFound404()
+	}
+	for _, vpcID := range c.subnets {
+		if vpcID == id {
+			return fmt.Errorf("VPC %s is in use", id)
+		}
+	}
+	delete(c.vpcs, id)
+	return nil
+}
+
+func (c *fakeClient) CreateSubnet(vpcID, _ string) (*subnets.Subnet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.vpcs[vpcID]; !ok {
+		return nil, notFound404()
+	}
+	id := c.newID("subnet")
+	c.subnets[id] = vpcID
+	return &subnets.Subnet{ID: id, VPC_ID: vpcID, Status: "UNKNOWN"}, nil
+}
+
+func (c *fakeClient) GetSubnetStatus(id string) (*subnets.Subnet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subnets[id]; !ok {
+		return nil, notFound404()
+	}
+	return &subnets.Subnet{ID: id, Status: "ACTIVE"}, nil
+}
+
+func (c *fakeClient) DeleteSubnet(_, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subnets[id]; !ok {
+		return notFound404()
+	}
+	for _, instance := range c.instances {
+		if instance.SubnetID == id {
+			return fmt.Errorf("subnet %s is in use", id)
+		}
+	}
+	delete(c.subnets, id)
+	return nil
+}
+
+func (c *fakeClient) CreateSecurityGroup(name string, _ ...services.PortRange) (*secgroups.SecurityGroup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.newID("sg")
+	c.groups[id] = name
+	return &secgroups.SecurityGroup{ID: id, Name: name}, nil
+}
+
+func (c *fakeClient) DeleteSecurityGroup(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.groups[id]; !ok {
+		return notFound404()
+	}
+	for _, instance := range c.instances {
+		for _, group := range instance.SecurityGroups {
+			if group == id {
+				return fmt.Errorf("security group %s is in use", id)
+			}
+		}
+	}
+	delete(c.groups, id)
+	return nil
+}
+
+func (c *fakeClient) CreateInstance(opts *services.ExtendedServerOpts) (*servers.Server, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failInstances[opts.Name] {
+		return nil, fmt.Errorf("no capacity for %s", opts.Name)
+	}
+	id := c.newID("instance")
+	c.instances[id] = &fakeInstance{
+		Name:           opts.Name,
+		SubnetID:       opts.SubnetID,
+		KeyPairName:    opts.KeyPairName,
+		SecurityGroups: opts.SecurityGroups,
+		UserData:       opts.UserData,
+	}
+	return &servers.Server{ID: id, Name: opts.Name, Status: "BUILD"}, nil
+}
+
+func (c *fakeClient) GetInstanceStatus(id string) (*servers.Server, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	instance, ok := c.instances[id]
+	if !ok {
+		return nil, notFound404()
+	}
+	instance.Polled = true
+	return &servers.Server{ID: id, Name: instance.Name, Status: services.InstanceStatusRunning}, nil
+}
+
+func (c *fakeClient) AddTags(id string, tags []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	instance, ok := c.instances[id]
+	if !ok {
+		return notFound404()
+	}
+	instance.Tags = append(instance.Tags, tags...)
+	return nil
+}
+
+func (c *fakeClient) DeleteInstance(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.instances[id]; !ok {
+		return notFound404()
+	}
+	delete(c.instances, id)
+	return nil
+}
+
+func (c *fakeClient) CreateEIP(*services.ElasticIPOpts) (*eips.PublicIp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.newID("eip")
+	address := fmt.Sprintf("192.0.2.%d", c.lastID)
+	c.eips[address] = id
+	return &eips.PublicIp{ID: id, PublicAddress: address, Status: "PENDING_CREATE"}, nil
+}
+
+func (c *fakeClient) GetEIPStatus(id string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, eipID := range c.eips {
+		if eipID == id {
+			return "DOWN", nil
+		}
+	}
+	return "", notFound404()
+}
+
+func (c *fakeClient) BindFloatingIP(address, instanceID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	instance, ok := c.instances[instanceID]
+	if _, exists := c.eips[address]; !ok || !exists {
+		return notFound404()
+	}
+	instance.FloatingIP = address
+	return nil
+}
+
+func (c *fakeClient) DeleteFloatingIP(address string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.eips[address]; !ok {
+		return notFound404()
+	}
+	delete(c.eips, address)
+	return nil
+}
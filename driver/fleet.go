@@ -0,0 +1,414 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hashicorp/go-multierror"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/servers"
+	"github.com/huaweicloud/golangsdk/openstack/ecs/v1/cloudservers"
+)
+
+// MachineSpec describes a machine created as a part of a fleet
+type MachineSpec struct {
+	MachineName string
+	StorePath   string
+	// Flags override fleet flags for the machine, network options are always taken from fleet flags
+	Flags drivers.DriverOptions
+}
+
+// FleetOpts describes machines sharing VPC, subnet and security groups
+type FleetOpts struct {
+	// Name and StorePath are used for the driver holding shared network resources
+	Name      string
+	StorePath string
+	// Flags configure shared network and machines without own flags
+	Flags    drivers.DriverOptions
+	Machines []MachineSpec
+	// Batch creates all instances with a single ECS request, machines can't have own flags then.
+	// Instances are created one by one if user data rendered from template differs between machines
+	Batch bool
+}
+
+// Fleet is a set of machines sharing network resources
+type Fleet struct {
+	// Network holds shared resources, removing it removes resources created for the fleet,
+	// including the key pair of batch created machines.
+	// Machine drivers don't manage shared resources, so a machine can be removed separately.
+	Network *Driver
+	// Machines are drivers of created machines in order of specs
+	Machines []*Driver
+	// Failed maps names of machines which are not created to errors, their resources are removed
+	Failed map[string]error
+}
+
+func (o *FleetOpts) check() error {
+	if o.Name == "" {
+		return fmt.Errorf("fleet name is required")
+	}
+	if len(o.Machines) == 0 {
+		return fmt.Errorf("fleet has no machines")
+	}
+	names := map[string]bool{o.Name: true}
+	for _, spec := range o.Machines {
+		if spec.MachineName == "" {
+			return fmt.Errorf("machine name is required")
+		}
+		if names[spec.MachineName] {
+			return fmt.Errorf("duplicate machine name `%s`", spec.MachineName)
+		}
+		names[spec.MachineName] = true
+		if o.Batch && spec.Flags != nil {
+			return fmt.Errorf("machine `%s` has own flags, which is not supported for batch creation", spec.MachineName)
+		}
+	}
+	return nil
+}
+
+// CreateFleet creates shared network once and then machines using it.
+//
+// Machines failed to be created are reported in `Fleet.Failed` and returned error, other machines
+// are kept. If no machine is created, shared network is removed and nil fleet is returned.
+func CreateFleet(opts *FleetOpts) (*Fleet, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	network := NewDriver(opts.Name, opts.StorePath)
+	if err := network.SetConfigFromFlags(opts.Flags); err != nil {
+		return nil, err
+	}
+	if err := network.createNetwork(); err != nil {
+		return nil, err
+	}
+
+	fleet := &Fleet{Network: network, Failed: make(map[string]error)}
+	var pending []*Driver
+	for _, spec := range opts.Machines {
+		d, err := newFleetMachine(network, spec, opts.Flags)
+		if err != nil {
+			fleet.Failed[spec.MachineName] = err
+			continue
+		}
+		pending = append(pending, d)
+	}
+	if opts.Batch && len(pending) > 0 {
+		for i, err := range batchCreateInstances(network, pending) {
+			if err != nil {
+				fleet.Failed[pending[i].MachineName] = err
+			}
+		}
+	}
+
+	errs := make([]error, len(pending))
+	var wg sync.WaitGroup
+	for i, d := range pending {
+		if fleet.Failed[d.MachineName] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, d *Driver) {
+			defer wg.Done()
+			errs[i] = d.Create()
+		}(i, d)
+	}
+	wg.Wait()
+	for i, d := range pending {
+		if errs[i] != nil {
+			fleet.Failed[d.MachineName] = errs[i]
+		}
+		if fleet.Failed[d.MachineName] == nil {
+			fleet.Machines = append(fleet.Machines, d)
+			continue
+		}
+		log.Warnf("Failed to create machine `%s`, removing its resources", d.MachineName)
+		// machine failed to be created has nothing worth backing up
+		d.BackupOnRemove = ""
+		if err := d.Remove(); err != nil {
+			fleet.Failed[d.MachineName] = multierror.Append(fleet.Failed[d.MachineName], err)
+		}
+	}
+
+	var result error
+	for _, spec := range opts.Machines {
+		if err, ok := fleet.Failed[spec.MachineName]; ok {
+			result = multierror.Append(result, fmt.Errorf("machine `%s`: %s", spec.MachineName, err))
+		}
+	}
+	if len(fleet.Machines) == 0 {
+		log.Warn("No machines are created, removing shared network")
+		if err := network.Remove(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		return nil, result
+	}
+	return fleet, result
+}
+
+// Remove removes all fleet machines and then shared network
+func (f *Fleet) Remove() error {
+	var errs error
+	for _, d := range f.Machines {
+		if err := d.Remove(); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("machine `%s`: %s", d.MachineName, err))
+		}
+	}
+	if errs != nil {
+		// shared network can't be removed while it's used
+		return errs
+	}
+	return f.Network.Remove()
+}
+
+// createNetwork creates VPC, subnet and security groups without an instance
+func (d *Driver) createNetwork() error {
+	stop := d.handleSignals()
+	defer stop()
+	if err := d.Authenticate(); err != nil {
+		return err
+	}
+	if err := d.initNetwork(); err != nil {
+		return err
+	}
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+	if err := d.resolveIDs(); err != nil {
+		return err
+	}
+	err := runGraph(d.context(), d.checkpointSteps(d.networkSteps()))
+	if saveErr := d.saveState(); saveErr != nil {
+		log.Warnf("Failed to save created resources: %s", saveErr)
+	}
+	if err != nil {
+		if rmErr := d.deleteResources(); rmErr != nil {
+			return fmt.Errorf("%s, failed to remove created resources: %s", err, rmErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// useNetwork makes the machine use network resources of the fleet without managing them
+func (d *Driver) useNetwork(network *Driver) {
+	d.VpcID = managedSting{Value: network.VpcID.Value}
+	d.SubnetID = managedSting{Value: network.SubnetID.Value}
+	d.ManagedSecurityGroup = ""
+	d.K8sSecurityGroup = ""
+	d.sharedGroupIDs = nil
+	for _, id := range []string{network.ManagedSecurityGroupID, network.K8sSecurityGroupID} {
+		if id != "" {
+			d.sharedGroupIDs = append(d.sharedGroupIDs, id)
+		}
+	}
+}
+
+func newFleetMachine(network *Driver, spec MachineSpec, fleetFlags drivers.DriverOptions) (*Driver, error) {
+	d := NewDriver(spec.MachineName, spec.StorePath)
+	flags := spec.Flags
+	if flags == nil {
+		flags = fleetFlags
+	}
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		return nil, err
+	}
+	if d.StorePath != "" {
+		if err := os.MkdirAll(d.ResolveStorePath("."), 0700); err != nil {
+			return nil, err
+		}
+	}
+	d.useNetwork(network)
+	return d, nil
+}
+
+// shareKeyPair makes the key pair of the first machine used by all machines. Key pair created
+// by the driver is owned by the network driver, so removing any machine keeps it,
+// and each machine gets own copy of the private key.
+func shareKeyPair(network *Driver, machines []*Driver) error {
+	leader := machines[0]
+	if leader.KeyPairName.DriverManaged {
+		network.KeyPairName = leader.KeyPairName
+		if err := network.saveState(); err != nil {
+			return err
+		}
+	}
+	var privateKey []byte
+	if !leader.UseSSHAgent {
+		key, err := ioutil.ReadFile(leader.GetSSHKeyPath())
+		if err != nil {
+			return err
+		}
+		privateKey = key
+	}
+	for _, d := range machines {
+		d.KeyPairName = managedSting{Value: leader.KeyPairName.Value}
+		if d.UseSSHAgent || d == leader {
+			continue
+		}
+		d.PrivateKeyFile = d.GetSSHKeyPath()
+		if err := ioutil.WriteFile(d.PrivateKeyFile, privateKey, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ecsClient returns client of native ECS API
+func (d *Driver) ecsClient() (*golangsdk.ServiceClient, error) {
+	return d.catalogClient(serviceECS, openstack.NewEcsV1)
+}
+
+// sameUserData renders user data of all machines and reports if it's the same for all of them,
+// templates can make it differ, e.g. by machine name
+func sameUserData(machines []*Driver) (bool, error) {
+	for _, d := range machines {
+		if err := d.getUserData(); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(d.UserData, machines[0].UserData) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// waitForJob waits for ECS job to finish, status of the job is returned even if waiting fails
+func (d *Driver) waitForJob(ecs *golangsdk.ServiceClient, jobID string) (*cloudservers.JobStatus, error) {
+	status := &cloudservers.JobStatus{}
+	err := d.waitFor(phaseBatch, fmt.Sprintf("batch job `%s`", jobID), func() (bool, string, error) {
+		status = &cloudservers.JobStatus{}
+		_, err := ecs.Get(ecs.ServiceURL("jobs", jobID), status, nil)
+		if err != nil {
+			return false, "", err
+		}
+		return status.Status == "SUCCESS" || status.Status == "FAIL", status.Status, nil
+	})
+	return status, err
+}
+
+// batchCreateInstances creates instances of all machines with a single request,
+// machines share the key pair of the first machine and get instances in unspecified order
+func batchCreateInstances(network *Driver, machines []*Driver) []error {
+	errs := make([]error, len(machines))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	same, err := sameUserData(machines)
+	if err != nil {
+		return fail(err)
+	}
+	if !same {
+		log.Warn("User data differs between machines, creating instances one by one")
+		return errs
+	}
+
+	leader := machines[0]
+	stop := leader.handleSignals()
+	defer stop()
+	if err := leader.Authenticate(); err != nil {
+		return fail(err)
+	}
+	if err := leader.initNetwork(); err != nil {
+		return fail(err)
+	}
+	if err := leader.initCompute(); err != nil {
+		return fail(err)
+	}
+	if err := leader.resolveIDs(); err != nil {
+		return fail(err)
+	}
+	if err := leader.prepareKeyPair(); err != nil {
+		return fail(err)
+	}
+	if err := shareKeyPair(network, machines); err != nil {
+		return fail(err)
+	}
+	ecs, err := leader.ecsClient()
+	if err != nil {
+		return fail(err)
+	}
+
+	var secGroups []cloudservers.SecurityGroup
+	for _, id := range append(append([]string{}, leader.SecurityGroupIDs...), leader.sharedGroupIDs...) {
+		secGroups = append(secGroups, cloudservers.SecurityGroup{ID: id})
+	}
+	createOpts := cloudservers.CreateOpts{
+		ImageRef:         leader.RootVolumeOpts.SourceID,
+		FlavorRef:        leader.FlavorID,
+		Name:             leader.MachineName,
+		UserData:         leader.UserData,
+		KeyName:          leader.KeyPairName.Value,
+		VpcId:            leader.VpcID.Value,
+		Nics:             []cloudservers.Nic{{SubnetId: leader.SubnetID.Value}},
+		Count:            len(machines),
+		RootVolume:       cloudservers.RootVolume{VolumeType: leader.RootVolumeOpts.Type, Size: leader.RootVolumeOpts.Size},
+		SecurityGroups:   secGroups,
+		AvailabilityZone: leader.AvailabilityZone,
+	}
+	if leader.ServerGroupID != "" {
+		createOpts.SchedulerHints = &cloudservers.SchedulerHints{Group: leader.ServerGroupID}
+	}
+	job, err := cloudservers.Create(ecs, createOpts).ExtractJobResponse()
+	if err != nil {
+		return fail(fmt.Errorf("error creating instances: %s", err))
+	}
+	log.Infof("Creating %d instances in batch job `%s`", len(machines), job.JobID)
+
+	status, err := leader.waitForJob(ecs, job.JobID)
+
+	// instances created by the job are assigned even if waiting fails, so they are removed with machines
+	var failures []error
+	next := 0
+	for _, sub := range status.Entities.SubJobs {
+		serverID := sub.Entities["server_id"]
+		if serverID == "" || next == len(machines) {
+			failures = append(failures, fmt.Errorf("instance creation failed: %s", sub.FailReason))
+			continue
+		}
+		machines[next].InstanceID = serverID
+		if sub.Status != "SUCCESS" {
+			errs[next] = fmt.Errorf("instance creation failed: %s", sub.FailReason)
+		}
+		next++
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if status.Status == "FAIL" && next == 0 {
+		return fail(fmt.Errorf("batch job `%s` failed with code %s: %s", job.JobID, status.ErrorCode, status.FailReason))
+	}
+	for i := next; i < len(machines); i++ {
+		if len(failures) > 0 {
+			errs[i], failures = failures[0], failures[1:]
+		} else {
+			errs[i] = fmt.Errorf("instance is not created by batch job `%s`", job.JobID)
+		}
+	}
+
+	for i, d := range machines[:next] {
+		if errs[i] != nil {
+			continue
+		}
+		// batch instances share the name
+		if err := servers.Update(leader.computeV2, d.InstanceID, servers.UpdateOpts{Name: d.MachineName}).Err; err != nil {
+			errs[i] = err
+			continue
+		}
+		if len(d.Tags) > 0 {
+			errs[i] = leader.client.AddTags(d.InstanceID, d.Tags)
+		}
+	}
+	return errs
+}
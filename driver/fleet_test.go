@@ -0,0 +1,319 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/servers"
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSession is fake client of a single driver sharing resources of the fake client with other drivers
+type fakeSession struct {
+	*fakeClient
+	Provider  *golangsdk.ProviderClient
+	ComputeV2 *golangsdk.ServiceClient
+	VPC       *golangsdk.ServiceClient
+}
+
+// useFakeClient makes all drivers use the same fake client with compute API served by the fake ECS,
+// returned function restores real client and stops the ECS
+func useFakeClient(ecs *fakeECS) func() {
+	newServicesClient = func(*clientconfig.ClientOpts) services.Client { return &fakeSession{fakeClient: ecs.client} }
+	newProviderClient = func(*clientconfig.ClientOpts, *http.Client) (*golangsdk.ProviderClient, error) {
+		provider := &golangsdk.ProviderClient{}
+		provider.EndpointLocator = func(eo golangsdk.EndpointOpts) (string, error) {
+			if eo.Type == serviceECS {
+				return ecs.URL + "/v1/project/", nil
+			}
+			return ecs.URL + "/v2.1/project/", nil
+		}
+		return provider, nil
+	}
+	return func() {
+		newServicesClient = services.NewClient
+		newProviderClient = authenticatedClient
+		ecs.Close()
+	}
+}
+
+func newFleetOpts(t *testing.T, names ...string) *FleetOpts {
+	storePath, err := ioutil.TempDir("", "otc-fleet")
+	require.NoError(t, err)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-token":             "token",
+			"otc-credentials-store": credentialsStoreConfig,
+			"otc-tags":              "fleet=test",
+		},
+		CreateFlags: NewDriver("", "").GetCreateFlags(),
+	}
+	opts := &FleetOpts{Name: "fleet", StorePath: storePath, Flags: flags}
+	for _, name := range names {
+		opts.Machines = append(opts.Machines, MachineSpec{MachineName: name, StorePath: storePath})
+	}
+	return opts
+}
+
+func TestCreateFleet(t *testing.T) {
+	client := newFakeClient()
+	defer useFakeClient(newFakeECS(client, 0))()
+	opts := newFleetOpts(t, "m1", "m2", "m3")
+	defer func() { _ = os.RemoveAll(opts.StorePath) }()
+
+	fleet, err := CreateFleet(opts)
+	require.NoError(t, err)
+	require.Len(t, fleet.Machines, 3)
+	assert.Empty(t, fleet.Failed)
+	assert.Len(t, client.vpcs, 1)
+	assert.Len(t, client.subnets, 1)
+	assert.Len(t, client.groups, 1)
+	assert.Len(t, client.instances, 3)
+	assert.Len(t, client.keyPairs, 3)
+
+	network := fleet.Network
+	assert.True(t, network.VpcID.DriverManaged)
+	assert.True(t, network.SubnetID.DriverManaged)
+	for i, d := range fleet.Machines {
+		assert.Equal(t, opts.Machines[i].MachineName, d.MachineName)
+		assert.Equal(t, managedSting{Value: network.VpcID.Value}, d.VpcID)
+		assert.Equal(t, managedSting{Value: network.SubnetID.Value}, d.SubnetID)
+		assert.Empty(t, d.ManagedSecurityGroupID)
+		instance := client.instances[d.InstanceID]
+		require.NotNil(t, instance)
+		assert.Equal(t, d.MachineName, instance.Name)
+		assert.Equal(t, []string{network.ManagedSecurityGroupID}, instance.SecurityGroups)
+		assert.Equal(t, []string{"fleet=test"}, instance.Tags)
+		assert.Equal(t, d.FloatingIP.Value, instance.FloatingIP)
+	}
+
+	// single machine removal keeps shared network
+	require.NoError(t, fleet.Machines[0].Remove())
+	assert.Len(t, client.instances, 2)
+	assert.Len(t, client.vpcs, 1)
+	fleet.Machines = fleet.Machines[1:]
+
+	require.NoError(t, fleet.Remove())
+	assert.Equal(t, 0, client.resources())
+}
+
+func TestCreateFleet_PartialFailure(t *testing.T) {
+	client := newFakeClient()
+	client.failInstances["m2"] = true
+	defer useFakeClient(newFakeECS(client, 0))()
+	opts := newFleetOpts(t, "m1", "m2", "m3")
+	defer func() { _ = os.RemoveAll(opts.StorePath) }()
+
+	fleet, err := CreateFleet(opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "machine `m2`: instance: no capacity for m2")
+	require.NotNil(t, fleet)
+	require.Len(t, fleet.Machines, 2)
+	assert.Equal(t, "m1", fleet.Machines[0].MachineName)
+	assert.Equal(t, "m3", fleet.Machines[1].MachineName)
+	assert.Contains(t, fleet.Failed, "m2")
+	// resources of the failed machine are removed
+	assert.Len(t, client.keyPairs, 2)
+	assert.Len(t, client.eips, 2)
+
+	require.NoError(t, fleet.Remove())
+	assert.Equal(t, 0, client.resources())
+}
+
+func TestCreateFleet_AllFailed(t *testing.T) {
+	client := newFakeClient()
+	client.failInstances["m1"] = true
+	client.failInstances["m2"] = true
+	defer useFakeClient(newFakeECS(client, 0))()
+	opts := newFleetOpts(t, "m1", "m2")
+	defer func() { _ = os.RemoveAll(opts.StorePath) }()
+
+	fleet, err := CreateFleet(opts)
+	require.Error(t, err)
+	assert.Nil(t, fleet)
+	assert.Equal(t, 0, client.resources())
+}
+
+func TestFleetOpts_Check(t *testing.T) {
+	opts := newFleetOpts(t, "m1", "m1")
+	defer func() { _ = os.RemoveAll(opts.StorePath) }()
+	assert.Error(t, opts.check())
+
+	opts = newFleetOpts(t, "m1")
+	defer func() { _ = os.RemoveAll(opts.StorePath) }()
+	opts.Batch = true
+	require.NoError(t, opts.check())
+	opts.Machines[0].Flags = opts.Flags
+	assert.Error(t, opts.check())
+}
+
+// fakeECS is a stand-in for compute and native ECS APIs working with instances of fake client
+type fakeECS struct {
+	*httptest.Server
+	client *fakeClient
+	// created is the number of instances created successfully by the batch job
+	created int
+	count   int
+	servers []string
+}
+
+func newFakeECS(client *fakeClient, created int) *fakeECS {
+	ecs := &fakeECS{client: client, created: created}
+	ecs.Server = httptest.NewServer(http.HandlerFunc(ecs.handle))
+	return ecs
+}
+
+func (e *fakeECS) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/project/cloudservers":
+		body := struct {
+			Server struct {
+				Name           string                   `json:"name"`
+				Count          int                      `json:"count"`
+				KeyName        string                   `json:"key_name"`
+				Nics           []map[string]string      `json:"nics"`
+				SecurityGroups []map[string]interface{} `json:"security_groups"`
+			} `json:"server"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		e.count = body.Server.Count
+		for i := 0; i < e.created; i++ {
+			opts := &services.ExtendedServerOpts{
+				CreateOpts:  &servers.CreateOpts{Name: body.Server.Name},
+				SubnetID:    body.Server.Nics[0]["subnet_id"],
+				KeyPairName: body.Server.KeyName,
+			}
+			instance, _ := e.client.CreateInstance(opts)
+			e.servers = append(e.servers, instance.ID)
+		}
+		_, _ = w.Write([]byte(`{"job_id": "job-1"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/project/jobs/job-1":
+		var subJobs []map[string]interface{}
+		for _, id := range e.servers {
+			subJobs = append(subJobs, map[string]interface{}{
+				"status": "SUCCESS", "entities": map[string]string{"server_id": id},
+			})
+		}
+		status := "SUCCESS"
+		for i := e.created; i < e.count; i++ {
+			status = "FAIL"
+			subJobs = append(subJobs, map[string]interface{}{"status": "FAIL", "fail_reason": "no capacity"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": status, "entities": map[string]interface{}{"sub_jobs": subJobs},
+		})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2.1/project/servers/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v2.1/project/servers/")
+		e.client.mu.Lock()
+		instance, ok := e.client.instances[id]
+		e.client.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		server := servers.Server{ID: id, Name: instance.Name, Status: services.InstanceStatusRunning}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"server": server})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2.1/project/os-security-groups/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v2.1/project/os-security-groups/")
+		e.client.mu.Lock()
+		_, ok := e.client.groups[id]
+		e.client.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"security_group": {"id": "%s"}}`, id)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v2.1/project/servers/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v2.1/project/servers/")
+		body := struct {
+			Server struct {
+				Name string `json:"name"`
+			} `json:"server"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		e.client.mu.Lock()
+		e.client.instances[id].Name = body.Server.Name
+		e.client.mu.Unlock()
+		_, _ = fmt.Fprintf(w, `{"server": {"id": "%s", "name": "%s"}}`, id, body.Server.Name)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCreateFleet_BatchUserDataTemplate(t *testing.T) {
+	client := newFakeClient()
+	ecs := newFakeECS(client, 2)
+	defer useFakeClient(ecs)()
+	opts := newFleetOpts(t, "m1", "m2")
+	defer func() { _ = os.RemoveAll(opts.StorePath) }()
+	opts.Batch = true
+	values := opts.Flags.(*drivers.CheckDriverOptions).FlagsValues
+	values["otc-user-data-raw"] = "#!/bin/sh\nhostname {{.MachineName}}"
+	values["otc-user-data-template"] = true
+
+	fleet, err := CreateFleet(opts)
+	require.NoError(t, err)
+	require.Len(t, fleet.Machines, 2)
+	// machines get own user data, so no batch request is sent
+	assert.Equal(t, 0, ecs.count)
+	for _, d := range fleet.Machines {
+		instance := client.instances[d.InstanceID]
+		require.NotNil(t, instance)
+		assert.Equal(t, "#!/bin/sh\nhostname "+d.MachineName, string(instance.UserData))
+	}
+	require.NoError(t, fleet.Remove())
+}
+
+func TestCreateFleet_Batch(t *testing.T) {
+	client := newFakeClient()
+	ecs := newFakeECS(client, 2)
+	defer useFakeClient(ecs)()
+	opts := newFleetOpts(t, "m1", "m2", "m3")
+	defer func() { _ = os.RemoveAll(opts.StorePath) }()
+	opts.Batch = true
+
+	fleet, err := CreateFleet(opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "machine `m3`: instance creation failed: no capacity")
+	assert.Equal(t, 3, ecs.count)
+	require.NotNil(t, fleet)
+	require.Len(t, fleet.Machines, 2)
+
+	// machines share key pair of the first one, which is owned by the network driver
+	assert.Len(t, client.keyPairs, 1)
+	first, second := fleet.Machines[0], fleet.Machines[1]
+	assert.True(t, fleet.Network.KeyPairName.DriverManaged)
+	assert.Equal(t, managedSting{Value: fleet.Network.KeyPairName.Value}, first.KeyPairName)
+	assert.Equal(t, managedSting{Value: first.KeyPairName.Value}, second.KeyPairName)
+	for _, d := range fleet.Machines {
+		instance := client.instances[d.InstanceID]
+		require.NotNil(t, instance)
+		assert.Equal(t, d.MachineName, instance.Name)
+		assert.Equal(t, []string{"fleet=test"}, instance.Tags)
+		assert.True(t, instance.Polled, "machine `%s` doesn't wait for instance", d.MachineName)
+		assert.Equal(t, d.GetSSHKeyPath(), d.PrivateKeyFile)
+		assert.FileExists(t, d.GetSSHKeyPath())
+	}
+
+	// removing the first machine keeps key pair and key file of others
+	require.NoError(t, first.Remove())
+	require.NoError(t, os.RemoveAll(first.ResolveStorePath(".")))
+	assert.Len(t, client.keyPairs, 1)
+	assert.FileExists(t, second.PrivateKeyFile)
+
+	fleet.Machines = fleet.Machines[1:]
+	require.NoError(t, fleet.Remove())
+	assert.Equal(t, 0, client.resources())
+}
@@ -0,0 +1,146 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hashicorp/go-multierror"
+)
+
+// graphStep is a step of operation which can run concurrently with steps it doesn't depend on
+type graphStep struct {
+	Name string
+	// After lists names of steps which must succeed before the step starts
+	After []string
+	Run   func() error
+}
+
+type stepResult struct {
+	name string
+	err  error
+}
+
+// checkGraph validates step names and dependencies, returning steps depending on each step
+func checkGraph(steps []graphStep) (map[string][]string, error) {
+	dependents := make(map[string][]string, len(steps))
+	for _, step := range steps {
+		if _, ok := dependents[step.Name]; ok {
+			return nil, fmt.Errorf("duplicate step `%s`", step.Name)
+		}
+		dependents[step.Name] = nil
+	}
+	for _, step := range steps {
+		for _, dep := range step.After {
+			if _, ok := dependents[dep]; !ok {
+				return nil, fmt.Errorf("step `%s` depends on unknown step `%s`", step.Name, dep)
+			}
+			dependents[dep] = append(dependents[dep], step.Name)
+		}
+	}
+
+	// Kahn's algorithm: all steps are reachable only if there are no cycles
+	pending := make(map[string]int, len(steps))
+	var ready []string
+	for _, step := range steps {
+		pending[step.Name] = len(step.After)
+		if len(step.After) == 0 {
+			ready = append(ready, step.Name)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if visited != len(steps) {
+		return nil, fmt.Errorf("steps have cyclic dependencies")
+	}
+	return dependents, nil
+}
+
+// runGraph runs every step as soon as all its dependencies succeed.
+//
+// After the first failure or cancellation of the context no new steps are started, running steps are
+// waited for. Errors of all failed steps are returned, errInterrupted is returned when steps are
+// not started or are stopped only because of cancellation.
+func runGraph(ctx context.Context, steps []graphStep) error {
+	dependents, err := checkGraph(steps)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]graphStep, len(steps))
+	pending := make(map[string]int, len(steps))
+	var ready []string
+	for _, step := range steps {
+		byName[step.Name] = step
+		pending[step.Name] = len(step.After)
+		if len(step.After) == 0 {
+			ready = append(ready, step.Name)
+		}
+	}
+
+	results := make(chan stepResult, len(steps))
+	running := 0
+	interrupted := false
+	var errs *multierror.Error
+	for {
+		if errs == nil && len(ready) > 0 {
+			if ctx.Err() != nil {
+				interrupted = true
+			} else {
+				for _, name := range ready {
+					step := byName[name]
+					log.Debugf("Starting step `%s`", name)
+					running++
+					go func() {
+						results <- stepResult{name: step.Name, err: step.Run()}
+					}()
+				}
+			}
+			ready = nil
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		switch {
+		case result.err == errInterrupted, result.err != nil && ctx.Err() != nil:
+			// requests of running steps are cancelled together with the context
+			interrupted = true
+		case result.err != nil:
+			errs = multierror.Append(errs, fmt.Errorf("%s: %s", result.name, result.err))
+		default:
+			for _, dependent := range dependents[result.name] {
+				pending[dependent]--
+				if pending[dependent] == 0 {
+					ready = append(ready, dependent)
+				}
+			}
+		}
+		if interrupted {
+			// nothing new is started after cancellation
+			ready = nil
+		}
+	}
+
+	if errs != nil {
+		if len(errs.Errors) == 1 {
+			return errs.Errors[0]
+		}
+		return errs
+	}
+	if interrupted {
+		return errInterrupted
+	}
+	return nil
+}
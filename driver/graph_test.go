@@ -0,0 +1,162 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stepLog records order of step starts and finishes
+type stepLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *stepLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *stepLog) index(event string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, e := range l.events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *stepLog) step(name string, err error) func() error {
+	return func() error {
+		l.add("start " + name)
+		time.Sleep(10 * time.Millisecond)
+		l.add("end " + name)
+		return err
+	}
+}
+
+func TestRunGraph_Order(t *testing.T) {
+	log := &stepLog{}
+	steps := []graphStep{
+		{Name: "instance", After: []string{"subnet", "group"}, Run: log.step("instance", nil)},
+		{Name: "vpc", Run: log.step("vpc", nil)},
+		{Name: "subnet", After: []string{"vpc"}, Run: log.step("subnet", nil)},
+		{Name: "group", Run: log.step("group", nil)},
+		{Name: "ip", After: []string{"instance"}, Run: log.step("ip", nil)},
+	}
+	require.NoError(t, runGraph(context.Background(), steps))
+	assert.Len(t, log.events, 10)
+	for _, step := range steps {
+		for _, dep := range step.After {
+			assert.True(t, log.index("end "+dep) < log.index("start "+step.Name),
+				"%s started before %s finished", step.Name, dep)
+		}
+	}
+}
+
+func TestRunGraph_Concurrent(t *testing.T) {
+	// each step waits for another one to start, so sequential execution would block
+	first, second := make(chan struct{}), make(chan struct{})
+	meet := func(own, other chan struct{}) func() error {
+		return func() error {
+			close(own)
+			select {
+			case <-other:
+				return nil
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("steps don't run concurrently")
+			}
+		}
+	}
+	steps := []graphStep{
+		{Name: "first", Run: meet(first, second)},
+		{Name: "second", Run: meet(second, first)},
+	}
+	require.NoError(t, runGraph(context.Background(), steps))
+}
+
+func TestRunGraph_Errors(t *testing.T) {
+	log := &stepLog{}
+	steps := []graphStep{
+		{Name: "vpc", Run: log.step("vpc", fmt.Errorf("vpc failed"))},
+		{Name: "group", Run: log.step("group", fmt.Errorf("group failed"))},
+		{Name: "key", Run: func() error {
+			// finishes after failures
+			time.Sleep(50 * time.Millisecond)
+			return log.step("key", nil)()
+		}},
+		{Name: "subnet", After: []string{"vpc"}, Run: log.step("subnet", nil)},
+		{Name: "instance", After: []string{"key"}, Run: log.step("instance", nil)},
+	}
+	err := runGraph(context.Background(), steps)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "vpc: vpc failed")
+	assert.Contains(t, err.Error(), "group: group failed")
+	// running steps are finished, but nothing is started after failure
+	assert.NotEqual(t, -1, log.index("end key"))
+	assert.Equal(t, -1, log.index("start subnet"))
+	assert.Equal(t, -1, log.index("start instance"))
+}
+
+func TestRunGraph_Interrupted(t *testing.T) {
+	log := &stepLog{}
+	ctx, cancel := context.WithCancel(context.Background())
+	steps := []graphStep{
+		{Name: "vpc", Run: func() error {
+			cancel()
+			return log.step("vpc", nil)()
+		}},
+		{Name: "subnet", After: []string{"vpc"}, Run: log.step("subnet", nil)},
+	}
+	assert.Equal(t, errInterrupted, runGraph(ctx, steps))
+	assert.NotEqual(t, -1, log.index("end vpc"))
+	assert.Equal(t, -1, log.index("start subnet"))
+
+	steps = []graphStep{
+		{Name: "vpc", Run: func() error { return errInterrupted }},
+		{Name: "subnet", After: []string{"vpc"}, Run: log.step("subnet", nil)},
+	}
+	assert.Equal(t, errInterrupted, runGraph(context.Background(), steps))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	steps = []graphStep{
+		{Name: "vpc", Run: func() error {
+			cancel()
+			return ctx.Err()
+		}},
+	}
+	assert.Equal(t, errInterrupted, runGraph(ctx, steps), "step failed because of cancellation is interrupted")
+}
+
+func TestRunGraph_Invalid(t *testing.T) {
+	noop := func() error { return nil }
+	cases := map[string][]graphStep{
+		"duplicate step": {{Name: "a", Run: noop}, {Name: "a", Run: noop}},
+		"unknown step":   {{Name: "a", After: []string{"b"}, Run: noop}},
+		"cyclic":         {{Name: "a", After: []string{"b"}, Run: noop}, {Name: "b", After: []string{"a"}, Run: noop}},
+	}
+	for expected, steps := range cases {
+		err := runGraph(context.Background(), steps)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), expected)
+	}
+}
+
+func TestDriver_CreationSteps(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	dependents, err := checkGraph(driver.creationSteps())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{stepInstance}, dependents[stepKeyPair])
+	assert.ElementsMatch(t, []string{stepInstanceIP}, dependents[stepEIP])
+	assert.ElementsMatch(t, []string{stepInstanceActive}, dependents[stepInstance])
+}
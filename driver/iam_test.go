@@ -0,0 +1,366 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fakeProjectID  = "fake-project-id"
+	fakeDomainName = "fake-domain"
+	fakeDomainID   = "fake-domain-id"
+	fakeUsername   = "fake-user"
+	fakePassword   = "fake-password"
+	fakeAccessKey  = "fake-access-key"
+	fakeSecretKey  = "fake-secret-key"
+
+	fakeAgencyName       = "fake-agency"
+	fakeAgencyDomain     = "customer-domain"
+	fakeDelegatedProject = "eu-de_customer"
+	fakeAgencyProjectID  = "customer-project-id"
+)
+
+type fakeToken struct {
+	expires   time.Time
+	projectID string
+}
+
+// fakeIAM is a local stand-in for IAM issuing short-lived tokens, service APIs
+// accepting only valid tokens are served by fakes of the services
+type fakeIAM struct {
+	*httptest.Server
+	ttl time.Duration
+
+	compute *fakeCompute
+	ims     *fakeIMS
+	cbr     *fakeCBR
+
+	mu     sync.Mutex
+	issued int
+	tokens map[string]fakeToken
+	// securityToken is required for AK/SK requests if set, empty value means expired credentials
+	securityToken *string
+	// assumed counts issued agency tokens
+	assumed int
+	// actions are server actions received by all services
+	actions []string
+}
+
+func newFakeIAM(ttl time.Duration) *fakeIAM {
+	iam := newUnstartedFakeIAM(ttl)
+	iam.Start()
+	return iam
+}
+
+func newUnstartedFakeIAM(ttl time.Duration) *fakeIAM {
+	iam := &fakeIAM{ttl: ttl, tokens: make(map[string]fakeToken)}
+	iam.compute = &fakeCompute{iam: iam}
+	iam.ims = &fakeIMS{iam: iam}
+	iam.cbr = &fakeCBR{iam: iam}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/auth/tokens", iam.handleTokens)
+	mux.HandleFunc("/v3/projects", iam.handleAKSK(iam.handleProjects))
+	mux.HandleFunc("/v3/auth/domains", iam.handleAKSK(iam.handleDomains))
+	mux.HandleFunc("/v3/services", iam.handleAKSK(iam.handleServices))
+	mux.HandleFunc("/v3/endpoints", iam.handleAKSK(iam.handleEndpoints))
+	mux.HandleFunc("/v2.1/", iam.compute.handle)
+	mux.HandleFunc("/ecs/", iam.compute.handleECS)
+	mux.HandleFunc("/ims/", iam.ims.handle)
+	mux.HandleFunc("/cbr/", iam.cbr.handle)
+	iam.Server = httptest.NewUnstartedServer(mux)
+	return iam
+}
+
+func (iam *fakeIAM) authURL() string {
+	return iam.URL + "/v3"
+}
+
+// Issued returns number of tokens issued so far
+func (iam *fakeIAM) Issued() int {
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	return iam.issued
+}
+
+// Assumed returns number of agency tokens issued so far
+func (iam *fakeIAM) Assumed() int {
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	return iam.assumed
+}
+
+func (iam *fakeIAM) issueToken(projectID string) (string, time.Time) {
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	iam.issued++
+	token := fmt.Sprintf("token-%d", iam.issued)
+	expires := time.Now().Add(iam.ttl)
+	iam.tokens[token] = fakeToken{expires: expires, projectID: projectID}
+	return token, expires
+}
+
+// tokenProject returns project ID of valid token
+func (iam *fakeIAM) tokenProject(token string) (string, bool) {
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	t, ok := iam.tokens[token]
+	if !ok || time.Now().After(t.expires) {
+		return "", false
+	}
+	return t.projectID, true
+}
+
+func (iam *fakeIAM) validToken(token string) bool {
+	_, ok := iam.tokenProject(token)
+	return ok
+}
+
+func (iam *fakeIAM) setSecurityToken(token string) {
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	iam.securityToken = &token
+}
+
+func (iam *fakeIAM) recordAction(action string) {
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	iam.actions = append(iam.actions, action)
+}
+
+// Actions returns server actions received so far
+func (iam *fakeIAM) Actions() []string {
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	return append([]string(nil), iam.actions...)
+}
+
+// validAKSK checks that request is signed with AK/SK, signature itself is not validated
+func (iam *fakeIAM) validAKSK(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "SDK-HMAC-SHA256 Credential="+fakeAccessKey+"/") {
+		return false
+	}
+	iam.mu.Lock()
+	defer iam.mu.Unlock()
+	if iam.securityToken == nil {
+		return r.Header.Get(securityTokenHeader) == ""
+	}
+	signed := strings.Contains(auth, strings.ToLower(securityTokenHeader))
+	return *iam.securityToken != "" && r.Header.Get(securityTokenHeader) == *iam.securityToken && signed
+}
+
+// requestProject returns project ID the request is authorized for
+func (iam *fakeIAM) requestProject(r *http.Request) (string, bool) {
+	if projectID, ok := iam.tokenProject(r.Header.Get(authTokenHeader)); ok {
+		return projectID, true
+	}
+	return fakeProjectID, iam.validAKSK(r)
+}
+
+func (iam *fakeIAM) handleAKSK(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !iam.validAKSK(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		handler(w, r)
+	}
+}
+
+func (iam *fakeIAM) handleProjects(w http.ResponseWriter, r *http.Request) {
+	projects := []map[string]string{}
+	if name := r.URL.Query().Get("name"); name == "" || name == defaultRegion {
+		projects = append(projects, map[string]string{"id": fakeProjectID, "name": defaultRegion})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"projects": projects,
+		"links":    map[string]interface{}{},
+	})
+}
+
+func (iam *fakeIAM) handleDomains(w http.ResponseWriter, r *http.Request) {
+	domains := []map[string]string{}
+	if name := r.URL.Query().Get("name"); name == "" || name == fakeDomainName {
+		domains = append(domains, map[string]string{"id": fakeDomainID, "name": fakeDomainName})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"domains": domains,
+		"links":   map[string]interface{}{},
+	})
+}
+
+func (iam *fakeIAM) handleServices(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"services": []map[string]string{{"id": "compute-id", "type": "compute"}},
+		"links":    map[string]interface{}{},
+	})
+}
+
+func (iam *fakeIAM) handleEndpoints(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"endpoints": []map[string]string{{
+			"id":         "compute-endpoint",
+			"service_id": "compute-id",
+			"interface":  "public",
+			"region":     defaultRegion,
+			"url":        iam.compute.url(fakeProjectID),
+		}},
+		"links": map[string]interface{}{},
+	})
+}
+
+type fakeAuthRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password struct {
+				User struct {
+					Name     string `json:"name"`
+					Password string `json:"password"`
+				} `json:"user"`
+			} `json:"password"`
+			Token struct {
+				ID string `json:"id"`
+			} `json:"token"`
+			AssumeRole struct {
+				DomainName string `json:"domain_name"`
+				AgencyName string `json:"xrole_name"`
+			} `json:"assume_role"`
+		} `json:"identity"`
+		Scope struct {
+			Project struct {
+				Name string `json:"name"`
+			} `json:"project"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+// authenticate returns project ID of token to be issued
+func (iam *fakeIAM) authenticate(r *http.Request, body *fakeAuthRequest) (string, bool) {
+	identity := body.Auth.Identity
+	for _, method := range identity.Methods {
+		switch method {
+		case "password":
+			valid := identity.Password.User.Name == fakeUsername && identity.Password.User.Password == fakePassword
+			return fakeProjectID, valid
+		case "token":
+			return iam.tokenProject(identity.Token.ID)
+		case "assume_role":
+			if _, ok := iam.requestProject(r); !ok {
+				return "", false
+			}
+			valid := identity.AssumeRole.AgencyName == fakeAgencyName &&
+				identity.AssumeRole.DomainName == fakeAgencyDomain &&
+				body.Auth.Scope.Project.Name == fakeDelegatedProject
+			if valid {
+				iam.mu.Lock()
+				iam.assumed++
+				iam.mu.Unlock()
+			}
+			return fakeAgencyProjectID, valid
+		}
+	}
+	return "", false
+}
+
+func (iam *fakeIAM) handleTokens(w http.ResponseWriter, r *http.Request) {
+	body := &fakeAuthRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	projectID, valid := iam.authenticate(r, body)
+	if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error": {"code": 401, "message": "The request you have made requires authentication."}}`))
+		return
+	}
+
+	token, expires := iam.issueToken(projectID)
+	w.Header().Set("X-Subject-Token", token)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"token": map[string]interface{}{
+			"expires_at": expires.UTC().Format(time.RFC3339),
+			"project": map[string]interface{}{
+				"id":     projectID,
+				"name":   defaultRegion,
+				"domain": map[string]string{"id": fakeDomainID},
+			},
+			"catalog": []map[string]interface{}{
+				{
+					"id":   "compute-id",
+					"type": "compute",
+					"name": "nova",
+					"endpoints": []map[string]string{
+						{
+							"id":        "compute-endpoint",
+							"interface": "public",
+							"region":    defaultRegion,
+							"url":       iam.compute.url(projectID),
+						},
+					},
+				},
+				{
+					"id":   "vpc-id",
+					"type": "network",
+					"name": "neutron",
+					"endpoints": []map[string]string{
+						{
+							"id":        "vpc-endpoint",
+							"interface": "public",
+							"region":    defaultRegion,
+							"url":       iam.URL + "/vpc/",
+						},
+					},
+				},
+				{
+					"id":   "ecs-id",
+					"type": "ecs",
+					"name": "ecs",
+					"endpoints": []map[string]string{
+						{
+							"id":        "ecs-endpoint",
+							"interface": "public",
+							"region":    defaultRegion,
+							"url":       fmt.Sprintf("%s/ecs/v1/%s", iam.URL, projectID),
+						},
+					},
+				},
+				{
+					"id":   "ims-id",
+					"type": "image",
+					"name": "glance",
+					"endpoints": []map[string]string{
+						{
+							"id":        "ims-endpoint",
+							"interface": "public",
+							"region":    defaultRegion,
+							"url":       iam.URL + "/ims/",
+						},
+					},
+				},
+				{
+					"id":   "cbr-id",
+					"type": "cbr",
+					"name": "cbr",
+					"endpoints": []map[string]string{
+						{
+							"id":        "cbr-endpoint",
+							"interface": "public",
+							"region":    defaultRegion,
+							"url":       fmt.Sprintf("%s/cbr/v3/%s", iam.URL, projectID),
+						},
+					},
+				},
+			},
+		},
+	})
+}
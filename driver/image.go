@@ -0,0 +1,151 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack"
+	"github.com/huaweicloud/golangsdk/openstack/ims/v2/cloudimages"
+	"github.com/huaweicloud/golangsdk/pagination"
+)
+
+// machineImageTag is IMS tag key holding name of the machine image is created from
+const machineImageTag = "docker-machine"
+
+// Types of images created from machine
+const (
+	// ImageTypeSystem is image of instance system disk
+	ImageTypeSystem = "system"
+	// ImageTypeWhole is full-ECS image containing all instance disks
+	ImageTypeWhole = "whole"
+)
+
+// ImageOpts describes private image created from machine instance
+type ImageOpts struct {
+	// Name of the image, `<machine name>-<UTC timestamp>` is used if not set
+	Name        string
+	Description string
+	// Type is ImageTypeSystem if not set
+	Type string
+	// VaultID is CBR vault used for backup of full-ECS image
+	VaultID string
+}
+
+type wholeImageOpts struct {
+	Name        string                 `json:"name" required:"true"`
+	Description string                 `json:"description,omitempty"`
+	InstanceID  string                 `json:"instance_id" required:"true"`
+	VaultID     string                 `json:"vault_id,omitempty"`
+	ImageTags   []cloudimages.ImageTag `json:"image_tags,omitempty"`
+}
+
+func (d *Driver) imsClient() (*golangsdk.ServiceClient, error) {
+	return d.catalogClient(serviceIMS, openstack.NewImageServiceV2)
+}
+
+// imsV1 returns client of IMS API v1 used for full-ECS images and jobs
+func imsV1(ims *golangsdk.ServiceClient) *golangsdk.ServiceClient {
+	v1 := *ims
+	v1.ResourceBase = ims.Endpoint + "v1/"
+	return &v1
+}
+
+// CreateImage creates private image from machine instance tagged with machine name, returning image ID
+func (d *Driver) CreateImage(opts *ImageOpts) (string, error) {
+	ims, err := d.imsClient()
+	if err != nil {
+		return "", err
+	}
+	name := opts.Name
+	if name == "" {
+		name = fmt.Sprintf("%s-%s", d.MachineName, time.Now().UTC().Format("20060102150405"))
+	}
+	tags := []cloudimages.ImageTag{{Key: machineImageTag, Value: d.MachineName}}
+
+	var result cloudimages.JobResult
+	switch opts.Type {
+	case "", ImageTypeSystem:
+		result = cloudimages.CreateImageByServer(ims, cloudimages.CreateByServerOpts{
+			Name:        name,
+			Description: opts.Description,
+			InstanceId:  d.InstanceID,
+			ImageTags:   tags,
+		})
+	case ImageTypeWhole:
+		body, err := golangsdk.BuildRequestBody(wholeImageOpts{
+			Name:        name,
+			Description: opts.Description,
+			InstanceID:  d.InstanceID,
+			VaultID:     opts.VaultID,
+			ImageTags:   tags,
+		}, "")
+		if err != nil {
+			return "", err
+		}
+		v1 := imsV1(ims)
+		_, result.Err = v1.Post(v1.ServiceURL("cloudimages", "wholeimages", "action"), body, &result.Body,
+			&golangsdk.RequestOpts{OkCodes: []int{200}})
+	default:
+		return "", fmt.Errorf("unknown image type `%s`, supported types are: %s, %s", opts.Type, ImageTypeSystem, ImageTypeWhole)
+	}
+	job, err := result.ExtractJobResponse()
+	if err != nil {
+		return "", fmt.Errorf("error creating image `%s`: %s", name, err)
+	}
+	log.Infof("Creating image `%s` of instance `%s` in job `%s`", name, d.InstanceID, job.JobID)
+
+	jobs := imsV1(ims)
+	status := &cloudimages.JobStatus{}
+	err = d.waitFor(phaseImage, fmt.Sprintf("image job `%s`", job.JobID), func() (bool, string, error) {
+		status = &cloudimages.JobStatus{}
+		_, err := jobs.Get(jobs.ServiceURL(jobs.ProjectID, "jobs", job.JobID), status, nil)
+		if err != nil {
+			return false, "", err
+		}
+		return status.Status == "SUCCESS" || status.Status == "FAIL", status.Status, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if status.Status == "FAIL" {
+		return "", fmt.Errorf("image job `%s` failed with code %s: %s", job.JobID, status.ErrorCode, status.FailReason)
+	}
+	log.Infof("Created image `%s` (%s)", name, status.Entities.ImageID)
+	return status.Entities.ImageID, nil
+}
+
+// latestMachineImage returns ID of the latest active image created from the machine
+func (d *Driver) latestMachineImage(machineName string) (string, error) {
+	ims, err := d.imsClient()
+	if err != nil {
+		return "", err
+	}
+	opts := cloudimages.ListOpts{
+		Imagetype: "private",
+		Status:    "active",
+		Tag:       fmt.Sprintf("%s.%s", machineImageTag, machineName),
+	}
+	var latest *cloudimages.Image
+	err = cloudimages.List(ims, opts).EachPage(func(page pagination.Page) (bool, error) {
+		images, err := cloudimages.ExtractImages(page)
+		if err != nil {
+			return false, err
+		}
+		for i := range images {
+			if latest == nil || images[i].CreatedAt.After(latest.CreatedAt) {
+				latest = &images[i]
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error listing images of machine `%s`: %s", machineName, err)
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no images of machine `%s` found", machineName)
+	}
+	log.Debugf("Using image `%s` (%s) of machine `%s`", latest.Name, latest.ID, machineName)
+	return latest.ID, nil
+}
@@ -0,0 +1,165 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_CreateImage(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.PollIntervals = []string{"10ms"}
+
+	systemID, err := driver.CreateImage(&ImageOpts{})
+	require.NoError(t, err)
+	wholeID, err := driver.CreateImage(&ImageOpts{Name: "whole", Type: ImageTypeWhole, VaultID: "vault"})
+	require.NoError(t, err)
+	_, err = driver.CreateImage(&ImageOpts{Type: "data"})
+	assert.Error(t, err)
+
+	require.Len(t, iam.ims.images, 2)
+	system, whole := iam.ims.images[0], iam.ims.images[1]
+	assert.Equal(t, systemID, system.ID)
+	assert.Equal(t, ImageTypeSystem, system.Type)
+	assert.Contains(t, system.Name, instanceName+"-")
+	assert.Equal(t, []string{machineImageTag + "." + instanceName}, system.Tags)
+	assert.Equal(t, wholeID, whole.ID)
+	assert.Equal(t, ImageTypeWhole, whole.Type)
+	assert.Equal(t, "whole", whole.Name)
+}
+
+func TestDriver_LatestMachineImage(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	now := time.Now().UTC()
+	iam.ims.images = []fakeImage{
+		{ID: "old", Tags: []string{"docker-machine.base"}, CreatedAt: now.Add(-time.Hour)},
+		{ID: "latest", Tags: []string{"docker-machine.base"}, CreatedAt: now},
+		{ID: "other", Tags: []string{"docker-machine.other"}, CreatedAt: now.Add(time.Hour)},
+	}
+
+	driver := newTLSDriver(iam)
+	imageID, err := driver.latestMachineImage("base")
+	require.NoError(t, err)
+	assert.Equal(t, "latest", imageID)
+
+	_, err = driver.latestMachineImage("unknown")
+	assert.Error(t, err)
+}
+
+func TestDriver_ImageFromMachineConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-token":              "token",
+			"otc-image-id":           "image-id",
+			"otc-image-from-machine": "base",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	assert.Error(t, driver.SetConfigFromFlags(flags))
+
+	delete(flags.FlagsValues, "otc-image-id")
+	flags.FlagsValues["otc-credentials-store"] = credentialsStoreConfig
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	assert.Equal(t, "base", driver.ImageFromMachine)
+}
+
+// fakeIMS serves IMS API accepting only tokens of fake IAM, images are created immediately
+// and image creation job ID is the image ID
+type fakeIMS struct {
+	iam *fakeIAM
+
+	mu sync.Mutex
+	// images are private images
+	images []fakeImage
+	// failJobs makes image jobs fail
+	failJobs bool
+}
+
+type fakeImage struct {
+	ID        string
+	Name      string
+	Type      string
+	Tags      []string
+	CreatedAt time.Time
+}
+
+func (s *fakeIMS) handle(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := s.iam.requestProject(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodPost && (path == "/ims/v2/cloudimages/action" || path == "/ims/v1/cloudimages/wholeimages/action"):
+		body := struct {
+			Name       string                   `json:"name"`
+			InstanceID string                   `json:"instance_id"`
+			ImageTags  []map[string]interface{} `json:"image_tags"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.InstanceID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		image := fakeImage{
+			ID:        fmt.Sprintf("image-%d", len(s.images)+1),
+			Name:      body.Name,
+			Type:      ImageTypeSystem,
+			CreatedAt: time.Now().UTC(),
+		}
+		if strings.Contains(path, "wholeimages") {
+			image.Type = ImageTypeWhole
+		}
+		for _, tag := range body.ImageTags {
+			image.Tags = append(image.Tags, fmt.Sprintf("%s.%s", tag["key"], tag["value"]))
+		}
+		s.images = append(s.images, image)
+		s.iam.recordAction("image " + body.InstanceID)
+		_, _ = fmt.Fprintf(w, `{"job_id": "%s"}`, image.ID)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, fmt.Sprintf("/ims/v1/%s/jobs/", projectID)):
+		status := "SUCCESS"
+		if s.failJobs {
+			status = "FAIL"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   status,
+			"entities": map[string]string{"image_id": strings.TrimPrefix(path, fmt.Sprintf("/ims/v1/%s/jobs/", projectID))},
+		})
+	case r.Method == http.MethodGet && path == "/ims/v2/cloudimages":
+		images := []map[string]interface{}{}
+		for _, image := range s.images {
+			for _, tag := range image.Tags {
+				if tag == r.URL.Query().Get("tag") {
+					images = append(images, map[string]interface{}{
+						"id":         image.ID,
+						"name":       image.Name,
+						"tags":       image.Tags,
+						"created_at": image.CreatedAt.Format(golangsdk.RFC3339Milli),
+					})
+				}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"images": images})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,59 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+var errInterrupted = errors.New("interrupted by signal")
+
+// handleSignals makes SIGINT and SIGTERM stop driver operation after the current step instead of
+// killing the plugin, returned function restores default signal handling
+func (d *Driver) handleSignals() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.ctx = ctx
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warnf("Received %s, stopping after the current step", sig)
+			cancel()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			cancel()
+			d.ctx = nil
+		})
+	}
+}
+
+func (d *Driver) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// checkpoint saves created resources and stops operation if it's interrupted
+func (d *Driver) checkpoint() error {
+	if err := d.saveState(); err != nil {
+		log.Warnf("Failed to save created resources: %s", err)
+	}
+	if d.context().Err() != nil {
+		return errInterrupted
+	}
+	return nil
+}
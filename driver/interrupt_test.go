@@ -0,0 +1,99 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_HandleSignals(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	stop := driver.handleSignals()
+	defer stop()
+	require.NoError(t, driver.checkpoint())
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(os.Interrupt))
+
+	select {
+	case <-driver.context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context is not cancelled after SIGINT")
+	}
+	assert.Equal(t, errInterrupted, driver.checkpoint())
+
+	stop()
+	assert.NoError(t, driver.context().Err())
+}
+
+func TestDriver_WaitForInterrupted(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.PollIntervals = []string{"1h"}
+	driver.WaitTimeouts = []string{"2h"}
+	stop := driver.handleSignals()
+	defer stop()
+
+	polls := 0
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		process, _ := os.FindProcess(os.Getpid())
+		_ = process.Signal(os.Interrupt)
+	}()
+	start := time.Now()
+	err := driver.waitFor(phaseInstance, "test", func() (bool, string, error) {
+		polls++
+		return false, "BUILD", nil
+	})
+	assert.Equal(t, errInterrupted, err)
+	assert.Equal(t, 1, polls)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestDriver_RequestInterrupted(t *testing.T) {
+	policy := defaultRetryPolicy
+	defaultRetryPolicy = retryPolicy{MaxRetries: 1, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	defer func() { defaultRetryPolicy = policy }()
+
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	driver := newTLSDriver(iam)
+	require.NoError(t, driver.initCompute())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	driver.ctx = ctx
+	iam.compute.injectFaults(http.StatusTooManyRequests)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second, "retry delay is not interrupted")
+}
+
+func TestDriver_DeleteResourcesInterrupted(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.InstanceID = "instance"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	driver.ctx = ctx
+
+	// deletion stops before the first step, so client is not required
+	err := driver.deleteResources()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), errInterrupted.Error())
+}
@@ -1,9 +1,11 @@
 package opentelekomcloud
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -11,10 +13,10 @@ import (
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnflag"
 	"github.com/docker/machine/libmachine/mcnutils"
-	"github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/state"
 	"github.com/hashicorp/go-multierror"
 	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack"
 	"github.com/huaweicloud/golangsdk/openstack/compute/v2/servers"
 	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
 	"github.com/opentelekomcloud-infra/crutch-house/services"
@@ -48,6 +50,12 @@ var (
 		// worker node(s)
 		{From: 30000, To: 32767},
 	}
+
+	// newServicesClient creates API client, it's replaced in tests not requiring real cloud
+	newServicesClient = services.NewClient
+	// newProviderClient authenticates provider client shared by all service clients of the driver,
+	// it's replaced together with newServicesClient
+	newProviderClient = authenticatedClient
 )
 
 type managedSting struct {
@@ -59,9 +67,24 @@ type managedSting struct {
 type Driver struct {
 	*drivers.BaseDriver
 	Cloud                  string             `json:"cloud,omitempty"`
+	EncryptedCloudsFile    string             `json:"encrypted_clouds_file,omitempty"`
+	EncryptedSecureFile    string             `json:"encrypted_secure_file,omitempty"`
+	DecryptionKeyFile      string             `json:"decryption_key_file,omitempty"`
 	AuthURL                string             `json:"auth_url,omitempty"`
 	CACert                 string             `json:"ca_cert,omitempty"`
 	ValidateCert           bool               `json:"validate_cert"`
+	Insecure               bool               `json:"insecure,omitempty"`
+	ClientCert             string             `json:"client_cert,omitempty"`
+	ClientKey              string             `json:"client_key,omitempty"`
+	HTTPProxy              string             `json:"http_proxy,omitempty"`
+	NoProxy                string             `json:"no_proxy,omitempty"`
+	EndpointOverrides      []string           `json:"endpoint_overrides,omitempty"`
+	WaitTimeouts           []string           `json:"wait_timeouts,omitempty"`
+	PollIntervals          []string           `json:"poll_intervals,omitempty"`
+	HardReboot             bool               `json:"hard_reboot,omitempty"`
+	BackupOnRemove         string             `json:"backup_on_remove,omitempty"`
+	BackupVaultID          string             `json:"backup_vault_id,omitempty"`
+	BackupForce            bool               `json:"backup_force,omitempty"`
 	DomainID               string             `json:"domain_id,omitempty"`
 	DomainName             string             `json:"domain_name,omitempty"`
 	Username               string             `json:"username,omitempty"`
@@ -71,18 +94,31 @@ type Driver struct {
 	Region                 string             `json:"region,omitempty"`
 	AccessKey              string             `json:"access_key,omitempty"`
 	SecretKey              string             `json:"secret_key,omitempty"`
+	SecurityToken          string             `json:"security_token,omitempty"`
+	AgencyName             string             `json:"agency_name,omitempty"`
+	AgencyDomainName       string             `json:"agency_domain_name,omitempty"`
+	DelegatedProject       string             `json:"delegated_project,omitempty"`
+	CredentialsStore       string             `json:"credentials_store,omitempty"`
+	CredentialsRef         string             `json:"credentials_ref,omitempty"`
+	CredentialsEnv         map[string]string  `json:"credentials_env,omitempty"`
+	CredentialsEnvNames    []string           `json:"-"`
 	AvailabilityZone       string             `json:"-"`
 	EndpointType           string             `json:"endpoint_type,omitempty"`
 	InstanceID             string             `json:"instance_id"`
-	FlavorName             string             `json:"-"`
-	FlavorID               string             `json:"-"`
+	FlavorName             string             `json:"flavor_name,omitempty"`
+	FlavorID               string             `json:"flavor_id,omitempty"`
 	ImageName              string             `json:"-"`
+	ImageFromMachine       string             `json:"-"`
 	KeyPairName            managedSting       `json:"key_pair"`
 	VpcName                string             `json:"-"`
 	VpcID                  managedSting       `json:"vpc_id"`
 	SubnetName             string             `json:"-"`
 	SubnetID               managedSting       `json:"subnet_id"`
 	PrivateKeyFile         string             `json:"private_key"`
+	PublicKeyFile          string             `json:"-"`
+	UseSSHAgent            bool               `json:"ssh_agent,omitempty"`
+	SSHKeyType             string             `json:"-"`
+	SSHKeyBits             int                `json:"-"`
 	SecurityGroups         []string           `json:"-"`
 	SecurityGroupIDs       []string           `json:"-"`
 	ServerGroup            string             `json:"-"`
@@ -96,11 +132,24 @@ type Driver struct {
 	RootVolumeOpts         *services.DiskOpts `json:"-"`
 	UserDataFile           string             `json:"-"`
 	UserData               []byte             `json:"-"`
+	UserDataTemplate       bool               `json:"-"`
+	UserDataParts          []string           `json:"-"`
+	UserDataGzip           bool               `json:"-"`
+	SSHPortSetup           bool               `json:"-"`
 	Tags                   []string           `json:"-"`
 	IPVersion              int                `json:"-"`
 	skipEIPCreation        bool
+	userDataReady          bool
 	eipConfig              *services.ElasticIPOpts
 	client                 services.Client
+	provider               *golangsdk.ProviderClient
+	computeV2              *golangsdk.ServiceClient
+	vpcV1                  *golangsdk.ServiceClient
+	httpClient             *http.Client
+	endpoints              *endpointRewriter
+	ctx                    context.Context
+	// sharedGroupIDs are security groups of a fleet, which are not managed by the machine
+	sharedGroupIDs []string
 }
 
 func (d *Driver) createVPC() error {
@@ -115,7 +164,7 @@ func (d *Driver) createVPC() error {
 		Value:         vpc.ID,
 		DriverManaged: true,
 	}
-	if err := d.client.WaitForVPCStatus(d.VpcID.Value, "OK"); err != nil {
+	if err := d.waitForVPCStatus(d.VpcID.Value, "OK"); err != nil {
 		return err
 	}
 	return nil
@@ -133,7 +182,7 @@ func (d *Driver) createSubnet() error {
 		Value:         subnet.ID,
 		DriverManaged: true,
 	}
-	if err := d.client.WaitForSubnetStatus(d.SubnetID.Value, "ACTIVE"); err != nil {
+	if err := d.waitForSubnetStatus(d.SubnetID.Value, "ACTIVE"); err != nil {
 		return err
 	}
 	return nil
@@ -195,6 +244,13 @@ func (d *Driver) resolveIDs() error {
 		}
 		d.FlavorID = flavID
 	}
+	if d.RootVolumeOpts.SourceID == "" && d.ImageFromMachine != "" {
+		imageID, err := d.latestMachineImage(d.ImageFromMachine)
+		if err != nil {
+			return err
+		}
+		d.RootVolumeOpts.SourceID = imageID
+	}
 	if d.RootVolumeOpts.SourceID == "" && d.ImageName != "" {
 		imageID, err := d.client.FindImage(d.ImageName)
 		if err != nil {
@@ -222,40 +278,67 @@ func (d *Driver) resolveIDs() error {
 	return nil
 }
 
+// Steps of machine creation
+const (
+	stepVPC            = "VPC"
+	stepSubnet         = "subnet"
+	stepSecGroup       = "security group"
+	stepK8sSecGroup    = "k8s security group"
+	stepKeyPair        = "key pair"
+	stepEIP            = "elastic IP"
+	stepInstance       = "instance"
+	stepInstanceActive = "active instance"
+	stepInstanceIP     = "instance IP"
+)
+
+// networkSteps returns steps creating network resources which can be shared by several machines
+func (d *Driver) networkSteps() []graphStep {
+	return []graphStep{
+		{Name: stepVPC, Run: d.createVPC},
+		{Name: stepSubnet, After: []string{stepVPC}, Run: d.createSubnet},
+		{Name: stepSecGroup, Run: d.createDefaultGroup},
+		{Name: stepK8sSecGroup, Run: d.createK8sGroup},
+	}
+}
+
+// creationSteps returns machine creation steps, steps not depending on each other run concurrently
+func (d *Driver) creationSteps() []graphStep {
+	return append(d.networkSteps(), []graphStep{
+		{Name: stepKeyPair, Run: d.prepareKeyPair},
+		{Name: stepEIP, Run: d.allocateFloatingIP},
+		{
+			Name:  stepInstance,
+			After: []string{stepSubnet, stepSecGroup, stepK8sSecGroup, stepKeyPair},
+			Run:   d.createInstance,
+		},
+		{Name: stepInstanceActive, After: []string{stepInstance}, Run: d.waitForInstance},
+		{Name: stepInstanceIP, After: []string{stepInstanceActive, stepEIP}, Run: d.assignIP},
+	}...)
+}
+
 func (d *Driver) createResources() error {
-	// network init
+	// clients are initialized before running concurrent steps
 	if err := d.initNetwork(); err != nil {
 		return err
 	}
 	if err := d.initCompute(); err != nil {
-	}
-	if err := d.resolveIDs(); err != nil {
 		return err
 	}
-	if err := d.createVPC(); err != nil {
-		return err
-	}
-	if err := d.createSubnet(); err != nil {
-		return err
-	}
-	if err := d.createDefaultGroup(); err != nil {
+	if err := d.resolveIDs(); err != nil {
 		return err
 	}
-	if err := d.createK8sGroup(); err != nil {
+	if err := d.checkpoint(); err != nil {
 		return err
 	}
-
-	return nil
+	return runGraph(d.context(), d.checkpointSteps(d.creationSteps()))
 }
 
-func (d *Driver) Authenticate() error {
-	if d.client != nil {
-		return nil
-	}
-	opts := &clientconfig.ClientOpts{
+func (d *Driver) clientOpts() *clientconfig.ClientOpts {
+	return &clientconfig.ClientOpts{
 		Cloud:        d.Cloud,
 		RegionName:   d.Region,
 		EndpointType: d.EndpointType,
+		YAMLOpts:     d.yamlOpts(),
 		AuthInfo: &clientconfig.AuthInfo{
 			AuthURL:     d.AuthURL,
 			Username:    d.Username,
@@ -269,27 +352,100 @@ func (d *Driver) Authenticate() error {
 			Token:       d.Token,
 		},
 	}
-	d.client = services.NewClient(opts)
-	return d.client.Authenticate()
 }
 
-func (d *Driver) createFloatingIP() error {
-	if d.FloatingIP.Value == "" {
-		eip, err := d.client.CreateEIP(d.eipConfig)
-		if err != nil {
+func (d *Driver) Authenticate() error {
+	if d.client != nil {
+		return nil
+	}
+	if err := d.resolveCredentials(); err != nil {
+		return err
+	}
+	opts := d.clientOpts()
+	transport, err := d.newTransport(opts)
+	if err != nil {
+		return err
+	}
+	d.httpClient = &http.Client{Transport: transport}
+	if d.AgencyName != "" {
+		if err := d.useAgency(opts); err != nil {
 			return err
 		}
-		if err := d.client.WaitForEIPActive(eip.ID); err != nil {
+	}
+	provider, err := newProviderClient(opts, d.httpClient)
+	if _, ok := err.(golangsdk.ErrDefault401); ok && opts.AuthInfo.Token != "" && d.Cloud == "" && d.AgencyName == "" {
+		if err := d.dropExpiredToken(opts); err != nil {
 			return err
 		}
-		d.FloatingIP = managedSting{Value: eip.PublicAddress, DriverManaged: true}
+		provider, err = newProviderClient(opts, d.httpClient)
 	}
-	if err := d.client.BindFloatingIP(d.FloatingIP.Value, d.InstanceID); err != nil {
+	if err != nil {
+		return err
+	}
+	client := newServicesClient(opts)
+	// crutch-house client uses the provider instead of authenticating on its own
+	if err := useServiceClients(client, map[string]interface{}{"Provider": provider}); err != nil {
 		return err
 	}
+	d.provider = provider
+	d.client = client
 	return nil
 }
 
+// dropExpiredToken makes client use stored secrets instead of the expired token
+func (d *Driver) dropExpiredToken(opts *clientconfig.ClientOpts) error {
+	if opts.AuthInfo.Token == "" || d.Cloud != "" {
+		return nil
+	}
+	if (d.Username == "" || d.Password == "") && (d.AccessKey == "" || d.SecretKey == "") {
+		return fmt.Errorf("token has expired and can't be refreshed without password or AK/SK, " +
+			"provide a new token with `-otc-token`")
+	}
+	log.Debug("Token has expired, authenticating with stored credentials")
+	opts.AuthInfo.Token = ""
+	d.Token = ""
+	return nil
+}
+
+// reauthenticate issues a new token using the same authentication method
+func (d *Driver) reauthenticate(opts *clientconfig.ClientOpts) (string, error) {
+	if d.AgencyName != "" {
+		if err := d.useAgency(opts); err != nil {
+			return "", fmt.Errorf("failed to refresh expired agency token: %s", err)
+		}
+		return opts.AuthInfo.Token, nil
+	}
+	if err := d.dropExpiredToken(opts); err != nil {
+		return "", err
+	}
+	provider, err := newProviderClient(opts, d.httpClient)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh expired token: %s", err)
+	}
+	return provider.Token(), nil
+}
+
+func (d *Driver) allocateFloatingIP() error {
+	if d.skipEIPCreation || d.FloatingIP.Value != "" {
+		return nil
+	}
+	eip, err := d.client.CreateEIP(d.eipConfig)
+	if err != nil {
+		return err
+	}
+	// set before waiting, so interrupted creation removes the EIP
+	d.FloatingIP = managedSting{Value: eip.PublicAddress, DriverManaged: true}
+	return d.waitForEIPActive(eip.ID)
+}
+
+// assignIP binds floating IP to the instance or uses instance local IP when EIP is not used
+func (d *Driver) assignIP() error {
+	if d.skipEIPCreation {
+		return d.useLocalIP()
+	}
+	return d.client.BindFloatingIP(d.FloatingIP.Value, d.InstanceID)
+}
+
 func (d *Driver) useLocalIP() error {
 	instance, err := d.client.GetInstanceStatus(d.InstanceID)
 	if err != nil {
@@ -307,61 +463,90 @@ func (d *Driver) useLocalIP() error {
 }
 
 // Create creates new ECS used for docker-machine
+//
+// Creation interrupted by SIGINT or SIGTERM stops after the current step and removes created resources.
+// IDs of created resources are saved in the machine directory, so `docker-machine rm` can remove them
+// if creation fails.
 func (d *Driver) Create() error {
-	if err := d.Authenticate(); err != nil {
-		return err
+	stop := d.handleSignals()
+	err := d.create()
+	stop()
+	if err := d.saveState(); err != nil {
+		log.Warnf("Failed to save created resources: %s", err)
 	}
-	if err := d.createResources(); err != nil {
+	if err != errInterrupted {
 		return err
 	}
-	if d.KeyPairName.Value != "" {
-		if err := d.loadSSHKey(); err != nil {
-			return err
-		}
-	} else {
-		d.KeyPairName = managedSting{
-			fmt.Sprintf("%s-%s", d.MachineName, mcnutils.GenerateRandomID()),
-			true,
-		}
-		if err := d.createSSHKey(); err != nil {
-			return err
-		}
-	}
-	if err := d.createInstance(); err != nil {
+
+	log.Warn("Machine creation is interrupted, removing created resources")
+	// second signal interrupts the rollback
+	stop = d.handleSignals()
+	defer stop()
+	if err := d.deleteResources(); err != nil {
+		return fmt.Errorf("machine creation is interrupted, failed to remove created resources "+
+			"(run `docker-machine rm` to retry): %s", err)
+	}
+	if err := d.removeState(); err != nil {
+		log.Warnf("Failed to remove saved resources: %s", err)
+	}
+	return fmt.Errorf("machine creation is interrupted, created resources are removed")
+}
+
+func (d *Driver) create() error {
+	if err := d.Authenticate(); err != nil {
 		return err
 	}
-	if d.skipEIPCreation {
-		if err := d.useLocalIP(); err != nil {
-			return err
-		}
-	} else {
-		if err := d.createFloatingIP(); err != nil {
-			return err
-		}
+	return d.createResources()
+}
+
+func (d *Driver) prepareKeyPair() error {
+	if d.UseSSHAgent {
+		return d.loadAgentKey()
 	}
-	return nil
+	if d.KeyPairName.Value != "" || d.PrivateKeyFile != "" {
+		return d.loadSSHKey()
+	}
+	d.KeyPairName = managedSting{d.newKeyPairName(), true}
+	return d.createSSHKey()
 }
 
 func (d *Driver) getUserData() error {
-	if d.UserDataFile == "" || len(d.UserData) != 0 {
+	if d.userDataReady {
 		return nil
 	}
-	userData, err := ioutil.ReadFile(d.UserDataFile)
+	if d.UserDataFile != "" && len(d.UserData) == 0 {
+		userData, err := ioutil.ReadFile(d.UserDataFile)
+		if err != nil {
+			return err
+		}
+		d.UserData = userData
+	}
+	parts, err := d.userDataParts()
+	if err != nil {
+		return err
+	}
+	userData, err := composeUserData(parts)
+	if err != nil {
+		return err
+	}
+	userData, err = d.fitUserData(userData)
 	if err != nil {
 		return err
 	}
 	d.UserData = userData
+	d.userDataReady = true
 	return nil
 }
 
 func (d *Driver) createInstance() error {
-	if d.InstanceID != "" {
-		return nil
-	}
 	if err := d.initCompute(); err != nil {
 		return err
 	}
-	secGroups := d.SecurityGroupIDs
+	if d.InstanceID != "" {
+		// instance is created in batch
+		return nil
+	}
+	secGroups := append(append([]string{}, d.SecurityGroupIDs...), d.sharedGroupIDs...)
 	if d.ManagedSecurityGroupID != "" {
 		secGroups = append(secGroups, d.ManagedSecurityGroupID)
 	}
@@ -394,17 +579,15 @@ func (d *Driver) createInstance() error {
 	d.InstanceID = instance.ID
 
 	if len(d.Tags) > 0 {
-		if err := d.client.AddTags(d.InstanceID, d.Tags); err != nil {
-			return err
-		}
-	}
-
-	if err := d.client.WaitForInstanceStatus(d.InstanceID, services.InstanceStatusRunning); err != nil {
-		return err
+		return d.client.AddTags(d.InstanceID, d.Tags)
 	}
 	return nil
 }
 
+func (d *Driver) waitForInstance() error {
+	return d.waitForInstanceStatus(d.InstanceID, services.InstanceStatusRunning)
+}
+
 func (d *Driver) DriverName() string {
 	return driverName
 }
@@ -417,11 +600,25 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Name of cloud in `clouds.yaml` file",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			Name:   "otc-encrypted-clouds-file",
+			EnvVar: "OS_ENCRYPTED_CLOUDS_FILE",
+			Usage:  "OpenPGP-encrypted `clouds.yaml` file used instead of plain one",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-encrypted-secure-file",
+			EnvVar: "OS_ENCRYPTED_SECURE_FILE",
+			Usage:  "OpenPGP-encrypted `secure.yaml` file used instead of plain one",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-decryption-key-file",
+			EnvVar: "OS_DECRYPTION_KEY_FILE",
+			Usage:  "OpenPGP private key used for decryption of clouds files, passphrase is read from `OS_CLOUDS_PASSPHRASE`",
+		},
 		mcnflag.StringFlag{
 			Name:   "otc-auth-url",
 			EnvVar: "OS_AUTH_URL",
-			Usage:  "OpenTelekomCloud authentication URL",
-			Value:  defaultAuthURL,
+			Usage:  "OpenTelekomCloud authentication URL, region default is used if not set",
 		},
 		mcnflag.StringFlag{
 			Name:   "otc-cacert",
@@ -429,6 +626,66 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "CA certificate bundle to verify against",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			Name:   "otc-client-cert",
+			EnvVar: "OS_CERT",
+			Usage:  "Client certificate for mutual TLS",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-client-key",
+			EnvVar: "OS_KEY",
+			Usage:  "Client certificate key for mutual TLS",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			Name:   "otc-insecure",
+			EnvVar: "OS_INSECURE",
+			Usage:  "Disable TLS certificate verification of API endpoints",
+		},
+		mcnflag.StringSliceFlag{
+			Name: "otc-endpoint-override",
+			Usage: "Service endpoint used instead of catalog one in form `<service>=<url>`, e.g. " +
+				"`compute=https://ecs.example.com/v2.1/%(project_id)s`. Can be used multiple times",
+		},
+		mcnflag.StringSliceFlag{
+			Name: "otc-wait-timeout",
+			Usage: "Timeout of waiting for resource status in form `<duration>` or `<phase>=<duration>`, " +
+				"phases are: instance, vpc, subnet, eip, secgroup, reboot, resize, image, backup, batch. Can be used multiple times",
+		},
+		mcnflag.BoolFlag{
+			Name:  "otc-hard-reboot",
+			Usage: "Always reboot the instance hard, otherwise soft reboot is escalated to hard one after `reboot` wait timeout",
+		},
+		mcnflag.StringFlag{
+			Name: "otc-backup-on-remove",
+			Usage: "Back up the instance before removing the machine: `image` creates IMS image, " +
+				"`cbr` creates CBR backup in the vault set by `otc-backup-vault-id`",
+		},
+		mcnflag.StringFlag{
+			Name:  "otc-backup-vault-id",
+			Usage: "CBR vault used for backup before removing the machine, `image` backup becomes full-ECS image",
+		},
+		mcnflag.BoolFlag{
+			Name:   "otc-backup-force",
+			EnvVar: "OTC_BACKUP_FORCE",
+			Usage:  "Remove the machine even if backup before removal fails",
+		},
+		mcnflag.StringSliceFlag{
+			Name: "otc-poll-interval",
+			Usage: "Interval of polling resource status in form `<duration>` or `<phase>=<duration>`. " +
+				"Can be used multiple times",
+		},
+		mcnflag.StringFlag{
+			Name:  "otc-http-proxy",
+			Usage: "Proxy used for API requests, HTTP_PROXY and HTTPS_PROXY are used by default",
+			Value: "",
+		},
+		mcnflag.StringFlag{
+			Name:  "otc-no-proxy",
+			Usage: "Comma-separated list of hosts and networks reached without proxy, NO_PROXY is used by default",
+			Value: "",
+		},
 		mcnflag.StringFlag{
 			Name:   "otc-domain-id",
 			EnvVar: "OS_DOMAIN_ID",
@@ -484,11 +741,46 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "OpenTelekomCloud secret access key for AK/SK auth",
 			EnvVar: "ACCESS_KEY_SECRET",
 		},
+		mcnflag.StringFlag{
+			Name:   "otc-security-token",
+			Usage:  "OpenTelekomCloud security token for temporary AK/SK auth",
+			EnvVar: "OS_SECURITY_TOKEN",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-agency-name",
+			Usage:  "OpenTelekomCloud IAM agency to be assumed for all ECS/VPC operations",
+			EnvVar: "OS_AGENCY_NAME",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-agency-domain-name",
+			Usage:  "OpenTelekomCloud domain which created the agency",
+			EnvVar: "OS_AGENCY_DOMAIN_NAME",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-delegated-project",
+			Usage:  "OpenTelekomCloud project of agency domain used for delegated access, region is used by default",
+			EnvVar: "OS_DELEGATED_PROJECT",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-credentials-store",
+			EnvVar: "OS_CREDENTIALS_STORE",
+			Usage:  "Where credentials are kept between driver calls: config, cloud, env or keyring",
+			Value:  defaultCredentialsStore,
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-keyring-entry",
+			EnvVar: "OS_KEYRING_ENTRY",
+			Usage:  "Name of OS keyring entry used by `keyring` credentials store, machine directory by default",
+		},
+		mcnflag.StringSliceFlag{
+			Name: "otc-credentials-env",
+			Usage: "Environment variable used by `env` credentials store in form `<credential>=<variable>`, " +
+				"credentials: password, access_key, secret_key, token, security_token, proxy_auth. Can be used multiple times",
+		},
 		mcnflag.StringFlag{
 			Name:   "otc-availability-zone",
 			EnvVar: "OS_AVAILABILITY_ZONE",
-			Usage:  "OpenTelekomCloud availability zone",
-			Value:  defaultAZ,
+			Usage:  "OpenTelekomCloud availability zone, region default is used if not set",
 		},
 		mcnflag.StringFlag{
 			Name:   "otc-available-zone",
@@ -503,8 +795,7 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			Name:   "otc-flavor-name",
 			EnvVar: "OS_FLAVOR_NAME",
-			Usage:  "OpenTelekomCloud flavor name to use for the instance",
-			Value:  defaultFlavor,
+			Usage:  "OpenTelekomCloud flavor name to use for the instance, region default is used if not set",
 		},
 		mcnflag.StringFlag{
 			Name:   "otc-image-id",
@@ -514,8 +805,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			Name:   "otc-image-name",
 			EnvVar: "OS_IMAGE_NAME",
-			Usage:  "OpenTelekomCloud image name to use for the instance",
-			Value:  defaultImage,
+			Usage:  "OpenTelekomCloud image name to use for the instance, region default is used if not set",
+		},
+		mcnflag.StringFlag{
+			Name:  "otc-image-from-machine",
+			Usage: "Name of the machine which latest private image is used for the instance, takes precedence over image name",
 		},
 		mcnflag.StringFlag{
 			Name:   "otc-keypair-name",
@@ -549,6 +843,27 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			EnvVar: "OS_PRIVATE_KEY_FILE",
 			Usage:  "Private key file to use for SSH (absolute path)",
 		},
+		mcnflag.StringFlag{
+			Name:   "otc-ssh-key-type",
+			EnvVar: "OS_SSH_KEY_TYPE",
+			Usage:  "Type of generated SSH key (one of rsa, ecdsa, ed25519)",
+			Value:  defaultSSHKeyType,
+		},
+		mcnflag.IntFlag{
+			Name:   "otc-ssh-key-bits",
+			EnvVar: "OS_SSH_KEY_BITS",
+			Usage:  "Size of generated SSH key in bits (RSA: 2048-16384, default 2048; ECDSA: 256, 384 or 521, default 256)",
+		},
+		mcnflag.StringFlag{
+			Name:   "otc-public-key-file",
+			EnvVar: "OS_PUBLIC_KEY_FILE",
+			Usage:  "Public key file to upload as new key pair, by default public key is derived from private key",
+		},
+		mcnflag.BoolFlag{
+			Name:   "otc-ssh-agent",
+			EnvVar: "OS_SSH_AGENT",
+			Usage:  "Use key from running ssh-agent (SSH_AUTH_SOCK) instead of private key file",
+		},
 		mcnflag.StringFlag{
 			Name:   "otc-user-data-file",
 			EnvVar: "OS_USER_DATA_FILE",
@@ -558,6 +873,18 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:  "otc-user-data-raw",
 			Usage: "Contents of user data file as a string",
 		},
+		mcnflag.StringSliceFlag{
+			Name:  "otc-user-data-part",
+			Usage: "User data part in form `<content-type>=<file>`, e.g. `cloud-config=config.yaml`. Can be used multiple times",
+		},
+		mcnflag.BoolFlag{
+			Name:  "otc-user-data-gzip",
+			Usage: "Compress user data with gzip if it exceeds ECS user data size limit",
+		},
+		mcnflag.BoolFlag{
+			Name:  "otc-user-data-template",
+			Usage: "Render user data as Go template with machine details and environment variables",
+		},
 		mcnflag.StringFlag{
 			Name:   "otc-token",
 			EnvVar: "OS_TOKEN",
@@ -624,6 +951,10 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Machine SSH port",
 			Value:  defaultSSHPort,
 		},
+		mcnflag.BoolFlag{
+			Name:  "otc-ssh-port-setup",
+			Usage: "Make sshd listen on `otc-ssh-port` by adding a script to user data, for images using default port",
+		},
 		mcnflag.StringFlag{
 			Name:   "otc-endpoint-type",
 			EnvVar: "OS_INTERFACE",
@@ -703,24 +1034,11 @@ func (d *Driver) GetState() (state.State, error) {
 	if err := d.initCompute(); err != nil {
 		return state.None, err
 	}
-	instance, err := d.client.GetInstanceStatus(d.InstanceID)
+	status, err := d.getInstanceStatus()
 	if err != nil {
 		return state.None, err
 	}
-	switch instance.Status {
-	case services.InstanceStatusRunning:
-		return state.Running, nil
-	case "PAUSED":
-		return state.Paused, nil
-	case services.InstanceStatusStopped:
-		return state.Stopped, nil
-	case "BUILDING":
-		return state.Starting, nil
-	case "ERROR":
-		return state.Error, nil
-	default:
-		return state.None, nil
-	}
+	return status.machineState(d.InstanceID)
 }
 
 func (d *Driver) Start() error {
@@ -730,7 +1048,7 @@ func (d *Driver) Start() error {
 	if err := d.client.StartInstance(d.InstanceID); err != nil {
 		return err
 	}
-	return d.client.WaitForInstanceStatus(d.InstanceID, services.InstanceStatusRunning)
+	return d.waitForInstanceStatus(d.InstanceID, services.InstanceStatusRunning)
 }
 
 func (d *Driver) Stop() error {
@@ -740,21 +1058,28 @@ func (d *Driver) Stop() error {
 	if err := d.client.StopInstance(d.InstanceID); err != nil {
 		return err
 	}
-	return d.client.WaitForInstanceStatus(d.InstanceID, services.InstanceStatusStopped)
+	return d.waitForInstanceStatus(d.InstanceID, services.InstanceStatusStopped)
 }
 
-func (d *Driver) Kill() error {
-	return d.Stop()
+// notDeleted returns error of deleting resource unless the resource doesn't exist
+func notDeleted(err error) error {
+	if _, ok := err.(golangsdk.ErrDefault404); ok {
+		return nil
+	}
+	return err
 }
 
 func (d *Driver) deleteInstance() error {
+	if d.InstanceID == "" {
+		return nil
+	}
 	if err := d.initCompute(); err != nil {
 		return err
 	}
 	if err := d.client.DeleteInstance(d.InstanceID); err != nil {
-		return err
+		return notDeleted(err)
 	}
-	err := d.client.WaitForInstanceStatus(d.InstanceID, "")
+	err := d.waitForInstanceStatus(d.InstanceID, "")
 	switch err.(type) {
 	case golangsdk.ErrDefault404:
 	default:
@@ -770,9 +1095,9 @@ func (d *Driver) deleteSubnet() error {
 	if d.SubnetID.DriverManaged {
 		err := d.client.DeleteSubnet(d.VpcID.Value, d.SubnetID.Value)
 		if err != nil {
-			return err
+			return notDeleted(err)
 		}
-		err = d.client.WaitForSubnetStatus(d.SubnetID.Value, "")
+		err = d.waitForSubnetStatus(d.SubnetID.Value, "")
 		switch err.(type) {
 		case golangsdk.ErrDefault404:
 		default:
@@ -789,9 +1114,9 @@ func (d *Driver) deleteVPC() error {
 	if d.VpcID.DriverManaged {
 		err := d.client.DeleteVPC(d.VpcID.Value)
 		if err != nil {
-			return err
+			return notDeleted(err)
 		}
-		err = d.client.WaitForVPCStatus(d.VpcID.Value, "")
+		err = d.waitForVPCStatus(d.VpcID.Value, "")
 		switch err.(type) {
 		case golangsdk.ErrDefault404:
 		default:
@@ -810,50 +1135,79 @@ func (d *Driver) deleteSecGroups() error {
 			continue
 		}
 		if err := d.client.DeleteSecurityGroup(id); err != nil {
-			return err
+			if err := notDeleted(err); err != nil {
+				return err
+			}
+			continue
 		}
-		if err := d.client.WaitForGroupDeleted(id); err != nil {
+		if err := d.waitForGroupDeleted(id); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (d *Driver) Remove() error {
-	var errs error
-	if err := d.Authenticate(); err != nil {
+func (d *Driver) deleteKeyPair() error {
+	if !d.KeyPairName.DriverManaged || d.KeyPairName.Value == "" {
+		return nil
+	}
+	if err := d.initCompute(); err != nil {
 		return err
 	}
-	if err := d.deleteInstance(); err != nil {
-		errs = multierror.Append(errs, err)
+	return notDeleted(d.client.DeleteKeyPair(d.KeyPairName.Value))
+}
+
+func (d *Driver) deleteFloatingIP() error {
+	if !d.FloatingIP.DriverManaged || d.FloatingIP.Value == "" {
+		return nil
 	}
-	if d.KeyPairName.DriverManaged {
-		if err := d.client.DeleteKeyPair(d.KeyPairName.Value); err != nil {
-			errs = multierror.Append(errs, err)
-		}
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+	return notDeleted(d.client.DeleteFloatingIP(d.FloatingIP.Value))
+}
+
+// deleteResources deletes all resources created by the driver,
+// interrupted deletion stops after the current step
+func (d *Driver) deleteResources() error {
+	var errs error
+	steps := []func() error{
+		d.deleteInstance, d.deleteKeyPair, d.deleteFloatingIP, d.deleteSubnet, d.deleteSecGroups, d.deleteVPC,
 	}
-	if d.FloatingIP.DriverManaged && d.FloatingIP.Value != "" {
-		if err := d.client.DeleteFloatingIP(d.FloatingIP.Value); err != nil {
+	for _, step := range steps {
+		if d.context().Err() != nil {
+			return multierror.Append(errs, errInterrupted)
+		}
+		if err := step(); err != nil {
 			errs = multierror.Append(errs, err)
 		}
 	}
-	if err := d.deleteSubnet(); err != nil {
-		errs = multierror.Append(errs, err)
-	}
-	if err := d.deleteSecGroups(); err != nil {
-		errs = multierror.Append(errs, err)
-	}
-	if err := d.deleteVPC(); err != nil {
-		errs = multierror.Append(errs, err)
-	}
 	return errs
 }
 
-func (d *Driver) Restart() error {
-	if err := d.Stop(); err != nil {
+func (d *Driver) Remove() error {
+	stop := d.handleSignals()
+	defer stop()
+	if err := d.restoreState(); err != nil {
+		log.Warnf("Failed to load resources saved during creation: %s", err)
+	}
+	if err := d.Authenticate(); err != nil {
 		return err
 	}
-	return d.Start()
+	if err := d.backupBeforeRemove(); err != nil {
+		if !d.BackupForce {
+			return fmt.Errorf("backup before removal failed, machine is not removed: %s", err)
+		}
+		log.Warnf("Backup before removal failed, removing machine anyway: %s", err)
+	}
+	errs := d.deleteResources()
+	if err := d.deleteKeyringCredentials(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+	return d.removeState()
 }
 
 // NewDriver create new driver instance
@@ -873,23 +1227,42 @@ func (d *Driver) initCompute() error {
 	if err := d.Authenticate(); err != nil {
 		return err
 	}
-	if err := d.client.InitCompute(); err != nil {
-		return err
+	if d.computeV2 == nil {
+		compute, err := openstack.NewComputeV2(d.provider, d.endpointOpts())
+		if err != nil {
+			return fmt.Errorf("error initializing %s client: %s", serviceCompute, err)
+		}
+		d.registerEndpoint(serviceCompute, compute)
+		if err := useServiceClients(d.client, map[string]interface{}{"ComputeV2": compute}); err != nil {
+			return err
+		}
+		d.computeV2 = compute
 	}
-	return nil
+	return d.client.InitCompute()
 }
 
 func (d *Driver) initNetwork() error {
 	if err := d.Authenticate(); err != nil {
 		return err
 	}
-	if err := d.client.InitNetwork(); err != nil {
-		return err
+	if d.vpcV1 == nil {
+		vpc, err := openstack.NewNetworkV1(d.provider, d.endpointOpts())
+		if err != nil {
+			return fmt.Errorf("error initializing %s client: %s", serviceVPC, err)
+		}
+		d.registerEndpoint(serviceVPC, vpc)
+		if err := useServiceClients(d.client, map[string]interface{}{"VPC": vpc}); err != nil {
+			return err
+		}
+		d.vpcV1 = vpc
 	}
-	return nil
+	return d.client.InitNetwork()
 }
 
 func (d *Driver) loadSSHKey() error {
+	if d.KeyPairName.Value == "" {
+		d.KeyPairName = managedSting{d.newKeyPairName(), true}
+	}
 	log.Debug("Loading Key Pair", d.KeyPairName.Value)
 	if err := d.initCompute(); err != nil {
 		return err
@@ -899,7 +1272,9 @@ func (d *Driver) loadSSHKey() error {
 	if err != nil {
 		return err
 	}
-	publicKey, err := d.client.GetPublicKey(d.KeyPairName.Value)
+	publicKey, err := d.ensureKeyPair(func() ([]byte, error) {
+		return d.readPublicKey(privateKey)
+	})
 	if err != nil {
 		return err
 	}
@@ -914,6 +1289,35 @@ func (d *Driver) loadSSHKey() error {
 	return nil
 }
 
+// ensureKeyPair returns public key of existing key pair or creates key pair with name
+// generated by the driver using public key returned by `newPublicKey`
+func (d *Driver) ensureKeyPair(newPublicKey func() ([]byte, error)) ([]byte, error) {
+	existing, err := d.client.FindKeyPair(d.KeyPairName.Value)
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" {
+		return d.client.GetPublicKey(d.KeyPairName.Value)
+	}
+	if !d.KeyPairName.DriverManaged {
+		return nil, fmt.Errorf(notFound, "key pair", d.KeyPairName.Value)
+	}
+	log.Debug("Key pair doesn't exist, uploading public key", d.KeyPairName.Value)
+	publicKey, err := newPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.createKeyPair(publicKey); err != nil {
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+func (d *Driver) newKeyPairName() string {
+	name := fmt.Sprintf("%s-%s", d.MachineName, mcnutils.GenerateRandomID())
+	return strings.Replace(name, ".", "_", -1)
+}
+
 func (d *Driver) createKeyPair(publicKey []byte) (string, error) {
 	kp, err := d.client.CreateKeyPair(d.KeyPairName.Value, string(publicKey))
 	if err != nil {
@@ -923,10 +1327,9 @@ func (d *Driver) createKeyPair(publicKey []byte) (string, error) {
 }
 
 func (d *Driver) createSSHKey() error {
-	d.KeyPairName.Value = strings.Replace(d.KeyPairName.Value, ".", "_", -1)
 	log.Debug("Creating Key Pair...", map[string]string{"Name": d.KeyPairName.Value})
 	keyPath := d.GetSSHKeyPath()
-	if err := ssh.GenerateSSHKey(keyPath); err != nil {
+	if err := generateSSHKey(keyPath, d.SSHKeyType, d.SSHKeyBits); err != nil {
 		return err
 	}
 	d.PrivateKeyFile = keyPath
@@ -948,7 +1351,23 @@ func (d *Driver) createSSHKey() error {
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.AuthURL = flags.String("otc-auth-url")
 	d.Cloud = flags.String("otc-cloud")
+	d.EncryptedCloudsFile = flags.String("otc-encrypted-clouds-file")
+	d.EncryptedSecureFile = flags.String("otc-encrypted-secure-file")
+	d.DecryptionKeyFile = flags.String("otc-decryption-key-file")
 	d.CACert = flags.String("otc-cacert")
+	d.ClientCert = flags.String("otc-client-cert")
+	d.ClientKey = flags.String("otc-client-key")
+	d.Insecure = flags.Bool("otc-insecure")
+	d.ValidateCert = !d.Insecure
+	d.HTTPProxy = flags.String("otc-http-proxy")
+	d.NoProxy = flags.String("otc-no-proxy")
+	d.EndpointOverrides = flags.StringSlice("otc-endpoint-override")
+	d.WaitTimeouts = flags.StringSlice("otc-wait-timeout")
+	d.PollIntervals = flags.StringSlice("otc-poll-interval")
+	d.HardReboot = flags.Bool("otc-hard-reboot")
+	d.BackupOnRemove = flags.String("otc-backup-on-remove")
+	d.BackupVaultID = flags.String("otc-backup-vault-id")
+	d.BackupForce = flags.Bool("otc-backup-force")
 	d.DomainID = flags.String("otc-domain-id")
 	d.DomainName = flags.String("otc-domain-name")
 	d.Username = flags.String("otc-username")
@@ -964,6 +1383,7 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.FlavorID = flags.String("otc-flavor-id")
 	d.FlavorName = flags.String("otc-flavor-name")
 	d.ImageName = flags.String("otc-image-name")
+	d.ImageFromMachine = flags.String("otc-image-from-machine")
 	d.VpcID = managedSting{Value: flags.String("otc-vpc-id")}
 	d.VpcName = flags.String("otc-vpc-name")
 	d.SubnetID = managedSting{Value: flags.String("otc-subnet-id")}
@@ -972,11 +1392,19 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.IPVersion = flags.Int("otc-ip-version")
 	d.SSHUser = flags.String("otc-ssh-user")
 	d.SSHPort = flags.Int("otc-ssh-port")
+	d.SSHPortSetup = flags.Bool("otc-ssh-port-setup")
 	d.KeyPairName = managedSting{Value: flags.String("otc-keypair-name")}
 	d.PrivateKeyFile = flags.String("otc-private-key-file")
+	d.PublicKeyFile = flags.String("otc-public-key-file")
+	d.UseSSHAgent = flags.Bool("otc-ssh-agent")
+	d.SSHKeyType = flags.String("otc-ssh-key-type")
+	d.SSHKeyBits = flags.Int("otc-ssh-key-bits")
 	d.Token = flags.String("otc-token")
 	d.UserDataFile = flags.String("otc-user-data-file")
 	d.UserData = []byte(flags.String("otc-user-data-raw"))
+	d.UserDataTemplate = flags.Bool("otc-user-data-template")
+	d.UserDataParts = flags.StringSlice("otc-user-data-part")
+	d.UserDataGzip = flags.Bool("otc-user-data-gzip")
 	d.ServerGroup = flags.String("otc-server-group")
 	d.ServerGroupID = flags.String("otc-server-group-id")
 	tags := flags.String("otc-tags")
@@ -985,6 +1413,13 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	}
 	d.AccessKey = flags.String("otc-access-key-id")
 	d.SecretKey = flags.String("otc-access-key-key")
+	d.SecurityToken = flags.String("otc-security-token")
+	d.AgencyName = flags.String("otc-agency-name")
+	d.AgencyDomainName = flags.String("otc-agency-domain-name")
+	d.DelegatedProject = flags.String("otc-delegated-project")
+	d.CredentialsStore = flags.String("otc-credentials-store")
+	d.CredentialsRef = flags.String("otc-keyring-entry")
+	d.CredentialsEnvNames = flags.StringSlice("otc-credentials-env")
 
 	d.RootVolumeOpts = &services.DiskOpts{
 		SourceID: flags.String("otc-image-id"),
@@ -1008,6 +1443,9 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		az = flags.String("otc-availability-zone")
 	}
 	d.AvailabilityZone = az
+	if err := d.setRegionDefaults(); err != nil {
+		return err
+	}
 
 	if sg := flags.String("otc-sec-groups"); sg != "" {
 		d.SecurityGroups = strings.Split(sg, ",")
@@ -1028,8 +1466,11 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 const errorBothOptions = "both %s and %s must be specified"
 
 func (d *Driver) checkConfig() error {
-	if (d.KeyPairName.Value != "" && d.PrivateKeyFile == "") || (d.KeyPairName.Value == "" && d.PrivateKeyFile != "") {
-		return fmt.Errorf(errorBothOptions, "KeyPairName", "PrivateKeyFile")
+	if err := d.checkSSHKeyConfig(); err != nil {
+		return err
+	}
+	if err := checkSSHKeyOpts(d.SSHKeyType, d.SSHKeyBits); err != nil {
+		return err
 	}
 	if d.Cloud == "" &&
 		(d.Username == "" || d.Password == "") &&
@@ -1037,8 +1478,43 @@ func (d *Driver) checkConfig() error {
 		(d.AccessKey == "" || d.SecretKey == "") {
 		return fmt.Errorf("at least one authorization method must be provided")
 	}
+	if d.SecurityToken != "" && (d.AccessKey == "" || d.SecretKey == "") {
+		return fmt.Errorf("security token can be used only with temporary AK/SK")
+	}
+	if err := d.checkEncryptedClouds(); err != nil {
+		return err
+	}
+	if err := d.checkAgencyConfig(); err != nil {
+		return err
+	}
+	if (d.ClientCert == "") != (d.ClientKey == "") {
+		return fmt.Errorf(errorBothOptions, "ClientCert", "ClientKey")
+	}
+	if d.HTTPProxy != "" {
+		if _, err := parseProxyURL(d.HTTPProxy); err != nil {
+			return err
+		}
+	}
+	if _, err := d.endpointOverrides(); err != nil {
+		return err
+	}
+	if err := d.checkWaitConfig(); err != nil {
+		return err
+	}
+	if err := checkBackupConfig(d.BackupOnRemove, d.BackupVaultID); err != nil {
+		return err
+	}
+	if d.ImageFromMachine != "" && d.RootVolumeOpts != nil && d.RootVolumeOpts.SourceID != "" {
+		return fmt.Errorf("both `-otc-image-id` and `-otc-image-from-machine` are defined")
+	}
 	if len(d.UserData) > 0 && d.UserDataFile != "" {
 		return fmt.Errorf("both `-otc-user-data` and `-otc-user-data` is defined")
 	}
+	if err := d.getUserData(); err != nil {
+		return err
+	}
+	if err := d.checkCredentialsStore(); err != nil {
+		return err
+	}
 	return nil
 }
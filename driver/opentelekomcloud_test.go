@@ -60,7 +60,9 @@ func defaultDriver() (*Driver, error) {
 }
 
 func TestDriver_SetConfigFromFlags(t *testing.T) {
-	driver := NewDriver(instanceName, "path")
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
 	flags := &drivers.CheckDriverOptions{
 		FlagsValues: map[string]interface{}{
 			"otc-cloud": "otc",
@@ -324,10 +326,13 @@ func TestDriver_CreateWithAKSK(t *testing.T) {
 }
 
 func TestDriver_SetConfigFromFlagsDeprecated(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
 	az := services.RandomString(5, "")
 	eipType := services.RandomString(5, "")
 
-	driverDeprecated := NewDriver(instanceName, "path")
+	driverDeprecated := NewDriver(instanceName, storePath)
 	flagsD := &drivers.CheckDriverOptions{
 		FlagsValues: map[string]interface{}{
 			"otc-cloud":           "otc",
@@ -339,7 +344,7 @@ func TestDriver_SetConfigFromFlagsDeprecated(t *testing.T) {
 	}
 	assert.NoError(t, driverDeprecated.SetConfigFromFlags(flagsD))
 
-	driverNew := NewDriver(instanceName, "path")
+	driverNew := NewDriver(instanceName, storePath)
 	flagsN := &drivers.CheckDriverOptions{
 		FlagsValues: map[string]interface{}{
 			"otc-cloud":             "otc",
@@ -0,0 +1,77 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/servers"
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+)
+
+// Kill powers the instance off without waiting for OS shutdown
+func (d *Driver) Kill() error {
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+	if err := d.forceStop(); err != nil {
+		return err
+	}
+	return d.waitForInstanceStatus(d.InstanceID, services.InstanceStatusStopped)
+}
+
+// forceStop stops the instance using native ECS API, compute API supports only soft stop
+func (d *Driver) forceStop() error {
+	ecs, err := d.ecsClient()
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"os-stop": map[string]interface{}{
+			"type":    "HARD",
+			"servers": []map[string]string{{"id": d.InstanceID}},
+		},
+	}
+	_, err = ecs.Post(ecs.ServiceURL("cloudservers", "action"), body, nil, &golangsdk.RequestOpts{
+		OkCodes: []int{200},
+	})
+	if err != nil {
+		return fmt.Errorf("error stopping instance `%s`: %s", d.InstanceID, err)
+	}
+	return nil
+}
+
+// Restart reboots the instance, soft reboot not finished in time is escalated to hard reboot
+func (d *Driver) Restart() error {
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+	if d.HardReboot {
+		return d.reboot(servers.HardReboot, phaseInstance)
+	}
+	err := d.reboot(servers.SoftReboot, phaseReboot)
+	if _, ok := err.(*waitTimeoutError); ok {
+		log.Warnf("Soft reboot of instance `%s` is not finished in time, rebooting hard: %s", d.InstanceID, err)
+		return d.reboot(servers.HardReboot, phaseInstance)
+	}
+	return err
+}
+
+func (d *Driver) reboot(method servers.RebootMethod, phase string) error {
+	if err := servers.Reboot(d.computeV2, d.InstanceID, servers.RebootOpts{Type: method}).Err; err != nil {
+		return err
+	}
+	description := fmt.Sprintf("instance `%s` to be rebooted", d.InstanceID)
+	return d.waitFor(phase, description, func() (bool, string, error) {
+		status, err := d.getInstanceStatus()
+		if err != nil {
+			return false, "", err
+		}
+		if status.Status == "ERROR" {
+			return false, status.Status, fmt.Errorf("instance `%s` is in ERROR state", d.InstanceID)
+		}
+		// task state is set to `rebooting` before reboot request is answered
+		done := status.Status == services.InstanceStatusRunning && status.TaskState == ""
+		return done, status.Status, nil
+	})
+}
@@ -0,0 +1,270 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_Kill(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.PollIntervals = []string{"10ms"}
+	require.NoError(t, driver.Kill())
+	assert.Equal(t, []string{"os-stop HARD"}, iam.Actions())
+
+	st, err := driver.GetState()
+	require.NoError(t, err)
+	assert.Equal(t, state.Stopped, st)
+}
+
+func TestDriver_KillEndpointOverride(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	gw := newFakeGateway(iam)
+	defer gw.Close()
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.EndpointOverrides = []string{"ecs=" + gw.URL + "/gateway/%(project_id)s"}
+	require.NoError(t, driver.initCompute())
+	// the gateway serves only compute API, so stop request fails after reaching it
+	require.Error(t, driver.Kill())
+	assert.Equal(t, []string{"/gateway/" + fakeProjectID + "/cloudservers/action"}, gw.Paths())
+	assert.Empty(t, iam.Actions())
+}
+
+func TestDriver_Restart(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.PollIntervals = []string{"10ms"}
+	require.NoError(t, driver.Restart())
+	assert.Equal(t, []string{"reboot SOFT"}, iam.Actions())
+
+	driver.HardReboot = true
+	require.NoError(t, driver.Restart())
+	assert.Equal(t, []string{"reboot SOFT", "reboot HARD"}, iam.Actions())
+}
+
+func TestDriver_RestartEscalation(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	iam.compute.stuckSoftReboot = true
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.WaitTimeouts = []string{"reboot=100ms"}
+	driver.PollIntervals = []string{"10ms"}
+	require.NoError(t, driver.Restart())
+	assert.Equal(t, []string{"reboot SOFT", "reboot HARD"}, iam.Actions())
+}
+
+// fakeCompute serves compute API and native ECS API server actions, accepting only tokens of fake IAM
+type fakeCompute struct {
+	iam *fakeIAM
+
+	mu sync.Mutex
+	// faults are statuses returned by compute API instead of the next responses
+	faults []int
+	// servers override status of active servers, nil status means deleted server
+	servers map[string]*instanceStatus
+	// failActions are server actions rejected with conflict
+	failActions map[string]bool
+	// stuckSoftReboot makes soft reboot never finish
+	stuckSoftReboot bool
+	// flavors map flavor names to extra specs, flavor ID is `flavor-<name>`
+	flavors map[string]map[string]string
+}
+
+func (c *fakeCompute) url(projectID string) string {
+	return fmt.Sprintf("%s/v2.1/%s", c.iam.URL, projectID)
+}
+
+func (c *fakeCompute) injectFaults(statuses ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = append(c.faults, statuses...)
+}
+
+func (c *fakeCompute) nextFault() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.faults) == 0 {
+		return 0
+	}
+	status := c.faults[0]
+	c.faults = c.faults[1:]
+	return status
+}
+
+func (c *fakeCompute) setServerStatus(id string, status *instanceStatus) {
+	if c.servers == nil {
+		c.servers = make(map[string]*instanceStatus)
+	}
+	c.servers[id] = status
+}
+
+func (c *fakeCompute) handle(w http.ResponseWriter, r *http.Request) {
+	if status := c.nextFault(); status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	projectID, ok := c.iam.requestProject(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	flavorsPrefix := fmt.Sprintf("/v2.1/%s/flavors/", projectID)
+	if strings.HasPrefix(r.URL.Path, flavorsPrefix) && r.Method == http.MethodGet {
+		c.handleFlavors(w, strings.TrimPrefix(r.URL.Path, flavorsPrefix))
+		return
+	}
+	prefix := fmt.Sprintf("/v2.1/%s/servers/", projectID)
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, prefix)
+	if strings.HasSuffix(id, "/action") && r.Method == http.MethodPost {
+		c.handleServerAction(w, r, strings.TrimSuffix(id, "/action"))
+		return
+	}
+	c.mu.Lock()
+	if r.Method == http.MethodDelete {
+		c.iam.recordAction("delete")
+		c.setServerStatus(id, nil)
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	status := &instanceStatus{
+		Status:          "ACTIVE",
+		PowerState:      powerStateRunning,
+		VolumesAttached: []attachedVolume{{ID: "system-volume"}},
+	}
+	if override, ok := c.servers[id]; ok {
+		status = override
+	}
+	c.mu.Unlock()
+	if status == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"server": map[string]interface{}{
+			"id":                                   id,
+			"status":                               status.Status,
+			"OS-EXT-STS:task_state":                status.TaskState,
+			"OS-EXT-STS:power_state":               status.PowerState,
+			"OS-EXT-AZ:availability_zone":          status.AvailabilityZone,
+			"os-extended-volumes:volumes_attached": status.VolumesAttached,
+		},
+	})
+}
+
+func (c *fakeCompute) handleServerAction(w http.ResponseWriter, r *http.Request, id string) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stopped := &instanceStatus{Status: "SHUTOFF", PowerState: 4}
+	running := &instanceStatus{Status: "ACTIVE", PowerState: powerStateRunning}
+	for action, value := range body {
+		if c.failActions[action] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		switch action {
+		case "reboot":
+			reboot := struct {
+				Type string `json:"type"`
+			}{}
+			_ = json.Unmarshal(value, &reboot)
+			action = "reboot " + reboot.Type
+			if reboot.Type == "SOFT" && c.stuckSoftReboot {
+				running.TaskState = "rebooting"
+			}
+			c.setServerStatus(id, running)
+		case "os-start":
+			c.setServerStatus(id, running)
+		case "os-stop", "confirmResize", "revertResize":
+			c.setServerStatus(id, stopped)
+		case "resize":
+			c.setServerStatus(id, &instanceStatus{Status: "VERIFY_RESIZE", PowerState: 4})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.iam.recordAction(action)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *fakeCompute) handleFlavors(w http.ResponseWriter, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if path == "detail" {
+		var list []map[string]string
+		for name := range c.flavors {
+			list = append(list, map[string]string{"id": "flavor-" + name, "name": name})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"flavors": list})
+		return
+	}
+	specs, ok := c.flavors[strings.TrimPrefix(strings.TrimSuffix(path, "/os-extra_specs"), "flavor-")]
+	if !ok || !strings.HasSuffix(path, "/os-extra_specs") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"extra_specs": specs})
+}
+
+// handleECS handles native ECS API server actions
+func (c *fakeCompute) handleECS(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := c.iam.requestProject(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != fmt.Sprintf("/ecs/v1/%s/cloudservers/action", projectID) || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body := struct {
+		Stop *struct {
+			Type    string              `json:"type"`
+			Servers []map[string]string `json:"servers"`
+		} `json:"os-stop"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Stop == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.iam.recordAction("os-stop " + body.Stop.Type)
+	for _, server := range body.Stop.Servers {
+		c.setServerStatus(server["id"], &instanceStatus{Status: "SHUTOFF", PowerState: 4})
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"job_id": "job"}`))
+}
@@ -0,0 +1,108 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyOptions contains proxy configuration of the driver, missing options are read from environment
+type proxyOptions struct {
+	HTTPProxy string
+	NoProxy   string
+}
+
+// normalizeProxy makes scheme-less `host:port` an HTTP proxy URL, as httpproxy does
+func normalizeProxy(proxy string) string {
+	if !strings.Contains(proxy, "://") {
+		return "http://" + proxy
+	}
+	return proxy
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(normalizeProxy(proxy))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL `%s`", redactProxy(proxy))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme `%s`", u.Scheme)
+	}
+	return u, nil
+}
+
+// redactProxy returns proxy URL which can be logged safely
+func redactProxy(proxy string) string {
+	u, err := url.Parse(normalizeProxy(proxy))
+	if err != nil {
+		// the URL can't be shown partially
+		return "<invalid>"
+	}
+	if u.User == nil {
+		return proxy
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// splitProxyAuth returns proxy URL without user info, which is returned separately
+func splitProxyAuth(proxy string) (string, string) {
+	if proxy == "" {
+		return "", ""
+	}
+	u, err := url.Parse(normalizeProxy(proxy))
+	if err != nil {
+		return redactProxy(proxy), ""
+	}
+	if u.User == nil {
+		return proxy, ""
+	}
+	auth := u.User.String()
+	u.User = nil
+	return u.String(), auth
+}
+
+// withProxyAuth adds user info to proxy URL not having own one
+func withProxyAuth(proxy, auth string) string {
+	if proxy == "" || auth == "" {
+		return proxy
+	}
+	u, err := url.Parse(normalizeProxy(proxy))
+	if err != nil || u.User != nil {
+		return proxy
+	}
+	withAuth, err := url.Parse(u.Scheme + "://" + auth + "@" + u.Host)
+	if err != nil {
+		return proxy
+	}
+	u.User = withAuth.User
+	return u.String()
+}
+
+// proxyFunc returns proxy function of the driver transport, options not set are taken from environment
+func (o *proxyOptions) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	config := httpproxy.FromEnvironment()
+	if o.HTTPProxy != "" {
+		if _, err := parseProxyURL(o.HTTPProxy); err != nil {
+			return nil, err
+		}
+		config.HTTPProxy = o.HTTPProxy
+		config.HTTPSProxy = o.HTTPProxy
+		log.Debugf("Using proxy %s for API requests", redactProxy(o.HTTPProxy))
+	}
+	if o.NoProxy != "" {
+		config.NoProxy = o.NoProxy
+	}
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
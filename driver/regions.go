@@ -0,0 +1,83 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regionDefaults are used for options not set explicitly
+type regionDefaults struct {
+	AuthURL          string
+	AvailabilityZone string
+	FlavorName       string
+	ImageName        string
+}
+
+var regions = map[string]regionDefaults{
+	"eu-de": {
+		AuthURL:          defaultAuthURL,
+		AvailabilityZone: defaultAZ,
+		FlavorName:       defaultFlavor,
+		ImageName:        defaultImage,
+	},
+	"eu-nl": {
+		AuthURL:          "https://iam.eu-nl.otc.t-systems.com/v3",
+		AvailabilityZone: "eu-nl-01",
+		FlavorName:       "s3.large.4",
+		ImageName:        defaultImage,
+	},
+	"eu-ch2": {
+		AuthURL:          "https://iam-pub.eu-ch2.sc.otc.t-systems.com/v3",
+		AvailabilityZone: "eu-ch2a",
+		FlavorName:       "s3.large.4",
+		ImageName:        defaultImage,
+	},
+}
+
+// setRegionDefaults sets options not set explicitly to defaults of the selected region
+func (d *Driver) setRegionDefaults() error {
+	defaults, ok := regions[d.Region]
+	if !ok {
+		return d.checkUnknownRegion()
+	}
+	if d.AuthURL == "" {
+		d.AuthURL = defaults.AuthURL
+	}
+	if d.AvailabilityZone == "" {
+		d.AvailabilityZone = defaults.AvailabilityZone
+	}
+	if d.FlavorName == "" {
+		d.FlavorName = defaults.FlavorName
+	}
+	if !d.imageSet() {
+		d.ImageName = defaults.ImageName
+	}
+	return nil
+}
+
+// imageSet checks if instance image is given by name, ID or source machine
+func (d *Driver) imageSet() bool {
+	return d.ImageName != "" || d.ImageFromMachine != "" || (d.RootVolumeOpts != nil && d.RootVolumeOpts.SourceID != "")
+}
+
+// checkUnknownRegion makes sure all region-specific options are set for region without defaults
+func (d *Driver) checkUnknownRegion() error {
+	var missing []string
+	if d.AuthURL == "" && d.Cloud == "" {
+		missing = append(missing, "`-otc-auth-url`")
+	}
+	if d.AvailabilityZone == "" {
+		missing = append(missing, "`-otc-availability-zone`")
+	}
+	if d.FlavorName == "" && d.FlavorID == "" {
+		missing = append(missing, "`-otc-flavor-name`")
+	}
+	if !d.imageSet() {
+		missing = append(missing, "`-otc-image-name`")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("region `%s` has no defaults, following options must be set: %s",
+			d.Region, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package opentelekomcloud
+
+import (
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func regionFlags(driver *Driver, values map[string]interface{}) *drivers.CheckDriverOptions {
+	values["otc-token"] = "token"
+	return &drivers.CheckDriverOptions{
+		FlagsValues: values,
+		CreateFlags: driver.GetCreateFlags(),
+	}
+}
+
+func TestDriver_RegionDefaults(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	require.NoError(t, driver.SetConfigFromFlags(regionFlags(driver, map[string]interface{}{})))
+	assert.Equal(t, defaultAuthURL, driver.AuthURL)
+	assert.Equal(t, defaultAZ, driver.AvailabilityZone)
+	assert.Equal(t, defaultFlavor, driver.FlavorName)
+	assert.Equal(t, defaultImage, driver.ImageName)
+
+	for region, defaults := range regions {
+		driver := NewDriver(instanceName, storePath)
+		flags := regionFlags(driver, map[string]interface{}{"otc-region": region})
+		require.NoError(t, driver.SetConfigFromFlags(flags), region)
+		assert.Equal(t, defaults.AuthURL, driver.AuthURL, region)
+		assert.Equal(t, defaults.AvailabilityZone, driver.AvailabilityZone, region)
+		assert.Equal(t, defaults.FlavorName, driver.FlavorName, region)
+		assert.Equal(t, defaults.ImageName, driver.ImageName, region)
+	}
+}
+
+func TestDriver_RegionExplicitOptions(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	flags := regionFlags(driver, map[string]interface{}{
+		"otc-region":            "eu-nl",
+		"otc-auth-url":          "https://iam.example.com/v3",
+		"otc-availability-zone": "eu-nl-02",
+		"otc-flavor-name":       "s3.xlarge.2",
+		"otc-image-name":        "Standard_Debian_10_latest",
+	})
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	assert.Equal(t, "https://iam.example.com/v3", driver.AuthURL)
+	assert.Equal(t, "eu-nl-02", driver.AvailabilityZone)
+	assert.Equal(t, "s3.xlarge.2", driver.FlavorName)
+	assert.Equal(t, "Standard_Debian_10_latest", driver.ImageName)
+}
+
+func TestDriver_UnknownRegion(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	err := driver.SetConfigFromFlags(regionFlags(driver, map[string]interface{}{"otc-region": "private"}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-otc-auth-url")
+
+	driver = NewDriver(instanceName, storePath)
+	flags := regionFlags(driver, map[string]interface{}{
+		"otc-region":            "private",
+		"otc-auth-url":          "https://iam.example.com/v3",
+		"otc-availability-zone": "private-01",
+		"otc-flavor-id":         "flavor-id",
+		"otc-image-id":          "image-id",
+	})
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+}
+
+func TestDriver_RegionDefaultsImageID(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	require.NoError(t, driver.SetConfigFromFlags(regionFlags(driver, map[string]interface{}{
+		"otc-image-id": "image-id",
+	})))
+	assert.Empty(t, driver.ImageName, "default image isn't used")
+	assert.Equal(t, "image-id", driver.RootVolumeOpts.SourceID)
+
+	driver = NewDriver(instanceName, storePath)
+	require.NoError(t, driver.SetConfigFromFlags(regionFlags(driver, map[string]interface{}{
+		"otc-image-from-machine": "other",
+	})))
+	assert.Empty(t, driver.ImageName)
+}
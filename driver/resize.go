@@ -0,0 +1,136 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/flavors"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/servers"
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+)
+
+// Flavor extra specs describing where flavor can be used
+const (
+	flavorStatusSpec = "cond:operation:status"
+	flavorAZSpec     = "cond:operation:az"
+)
+
+// flavorAvailable checks flavor status in the AZ, status of the AZ overrides flavor status
+func flavorAvailable(specs map[string]string, az string) error {
+	status := specs[flavorStatusSpec]
+	// AZ statuses are in form `eu-de-01(normal),eu-de-02(sellout)`
+	for _, item := range strings.Split(specs[flavorAZSpec], ",") {
+		item = strings.TrimSpace(item)
+		if az != "" && strings.HasPrefix(item, az+"(") && strings.HasSuffix(item, ")") {
+			status = strings.TrimSuffix(strings.TrimPrefix(item, az+"("), ")")
+		}
+	}
+	switch status {
+	case "abandon", "sellout":
+		return fmt.Errorf("flavor is not available in `%s`, status: %s", az, status)
+	}
+	return nil
+}
+
+// Resize changes instance flavor: the instance is stopped, resized, resize is confirmed and
+// the instance is started again if it was running, also when resize fails. FlavorID and FlavorName
+// are updated on success.
+func (d *Driver) Resize(flavorName string) (err error) {
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+	flavorID, err := d.client.FindFlavor(flavorName)
+	if err != nil {
+		return err
+	}
+	if flavorID == "" {
+		return fmt.Errorf(notFound, "flavor", flavorName)
+	}
+	if flavorID == d.FlavorID {
+		log.Infof("Instance `%s` already has flavor `%s`", d.InstanceID, flavorName)
+		return nil
+	}
+
+	status, err := d.getInstanceStatus()
+	if err != nil {
+		return err
+	}
+	az := status.AvailabilityZone
+	if az == "" {
+		az = d.AvailabilityZone
+	}
+	specs, err := flavors.ListExtraSpecs(d.computeV2, flavorID).Extract()
+	if err != nil {
+		return fmt.Errorf("error checking flavor `%s`: %s", flavorName, err)
+	}
+	if err := flavorAvailable(specs, az); err != nil {
+		return fmt.Errorf("can't resize to `%s`: %s", flavorName, err)
+	}
+
+	if status.Status == services.InstanceStatusRunning {
+		defer func() {
+			if startErr := d.startAfterResize(); startErr != nil {
+				if err == nil {
+					err = startErr
+					return
+				}
+				err = fmt.Errorf("%s, failed to start instance again: %s", err, startErr)
+			}
+		}()
+		log.Infof("Stopping instance `%s` before resize", d.InstanceID)
+		if err := d.Stop(); err != nil {
+			return err
+		}
+	}
+	log.Infof("Resizing instance `%s` to `%s`", d.InstanceID, flavorName)
+	if err := servers.Resize(d.computeV2, d.InstanceID, servers.ResizeOpts{FlavorRef: flavorID}).Err; err != nil {
+		return fmt.Errorf("error resizing instance `%s`: %s", d.InstanceID, err)
+	}
+	if err := d.waitForResizeStatus("VERIFY_RESIZE"); err != nil {
+		return err
+	}
+	if err := servers.ConfirmResize(d.computeV2, d.InstanceID).Err; err != nil {
+		log.Warnf("Failed to confirm resize of instance `%s`, reverting: %s", d.InstanceID, err)
+		if revertErr := servers.RevertResize(d.computeV2, d.InstanceID).Err; revertErr != nil {
+			return fmt.Errorf("error confirming resize: %s, revert failed: %s", err, revertErr)
+		}
+		if waitErr := d.waitForResizeStatus(services.InstanceStatusStopped); waitErr != nil {
+			return fmt.Errorf("error confirming resize: %s, revert failed: %s", err, waitErr)
+		}
+		return fmt.Errorf("error confirming resize: %s", err)
+	}
+	if err := d.waitForResizeStatus(services.InstanceStatusStopped); err != nil {
+		return err
+	}
+	d.FlavorID = flavorID
+	d.FlavorName = flavorName
+	return nil
+}
+
+// startAfterResize starts the instance stopped for resize, instance which is still running is kept
+func (d *Driver) startAfterResize() error {
+	status, err := d.getInstanceStatus()
+	if err != nil {
+		return err
+	}
+	if status.Status == services.InstanceStatusRunning {
+		return nil
+	}
+	log.Infof("Starting instance `%s` after resize", d.InstanceID)
+	return d.Start()
+}
+
+func (d *Driver) waitForResizeStatus(expected string) error {
+	description := statusDescription("instance", d.InstanceID, expected)
+	return d.waitFor(phaseResize, description, func() (bool, string, error) {
+		status, err := d.getInstanceStatus()
+		if err != nil {
+			return false, "", err
+		}
+		if status.Status == "ERROR" {
+			return false, status.Status, fmt.Errorf("instance `%s` is in ERROR state", d.InstanceID)
+		}
+		return status.Status == expected && status.TaskState == "", status.Status, nil
+	})
+}
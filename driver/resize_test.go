@@ -0,0 +1,155 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlavorAvailable(t *testing.T) {
+	cases := []struct {
+		specs     map[string]string
+		available bool
+	}{
+		{nil, true},
+		{map[string]string{flavorStatusSpec: "normal"}, true},
+		{map[string]string{flavorStatusSpec: "sellout"}, false},
+		{map[string]string{flavorStatusSpec: "abandon"}, false},
+		{map[string]string{flavorStatusSpec: "normal", flavorAZSpec: "eu-de-01(sellout),eu-de-02(normal)"}, false},
+		{map[string]string{flavorStatusSpec: "normal", flavorAZSpec: "eu-de-02(sellout)"}, true},
+		{map[string]string{flavorStatusSpec: "sellout", flavorAZSpec: "eu-de-02(sellout), eu-de-01(normal)"}, true},
+	}
+	for _, c := range cases {
+		err := flavorAvailable(c.specs, "eu-de-01")
+		assert.Equal(t, c.available, err == nil, "%v", c.specs)
+	}
+}
+
+func newResizeIAM() *fakeIAM {
+	iam := newFakeIAM(time.Minute)
+	iam.compute.flavors = map[string]map[string]string{
+		"s2.large.4":  {flavorStatusSpec: "normal"},
+		"s2.xlarge.4": {flavorStatusSpec: "normal"},
+		"s3.xlarge.4": {flavorStatusSpec: "normal", flavorAZSpec: "eu-de-01(sellout)"},
+	}
+	iam.compute.setServerStatus("instance", &instanceStatus{
+		Status: "ACTIVE", PowerState: powerStateRunning, AvailabilityZone: "eu-de-01",
+	})
+	return iam
+}
+
+func TestDriver_Resize(t *testing.T) {
+	iam := newResizeIAM()
+	defer iam.Close()
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.FlavorName = "s2.large.4"
+	driver.FlavorID = "flavor-s2.large.4"
+	driver.PollIntervals = []string{"10ms"}
+
+	require.NoError(t, driver.Resize("s2.large.4"))
+	assert.Empty(t, iam.Actions())
+
+	err := driver.Resize("s3.xlarge.4")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sellout")
+	assert.Error(t, driver.Resize("s9.huge.1"))
+	assert.Empty(t, iam.Actions())
+
+	require.NoError(t, driver.Resize("s2.xlarge.4"))
+	assert.Equal(t, []string{"os-stop", "resize", "confirmResize", "os-start"}, iam.Actions())
+	assert.Equal(t, "s2.xlarge.4", driver.FlavorName)
+	assert.Equal(t, "flavor-s2.xlarge.4", driver.FlavorID)
+}
+
+func TestDriver_ResizeStopped(t *testing.T) {
+	iam := newResizeIAM()
+	defer iam.Close()
+	iam.compute.setServerStatus("instance", &instanceStatus{Status: "SHUTOFF", PowerState: 4})
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.FlavorID = "flavor-s2.large.4"
+	driver.PollIntervals = []string{"10ms"}
+	require.NoError(t, driver.Resize("s3.xlarge.4"), "flavor is sold out only in eu-de-01")
+	assert.Equal(t, []string{"resize", "confirmResize"}, iam.Actions())
+	assert.Equal(t, "flavor-s3.xlarge.4", driver.FlavorID)
+}
+
+func TestDriver_ResizeFailed(t *testing.T) {
+	cases := map[string][]string{
+		"resize":        {"os-stop", "os-start"},
+		"confirmResize": {"os-stop", "resize", "revertResize", "os-start"},
+	}
+	for failed, actions := range cases {
+		iam := newResizeIAM()
+		iam.compute.failActions = map[string]bool{failed: true}
+
+		driver := newTLSDriver(iam)
+		driver.InstanceID = "instance"
+		driver.FlavorID = "flavor-s2.large.4"
+		driver.PollIntervals = []string{"10ms"}
+		assert.Error(t, driver.Resize("s2.xlarge.4"))
+		assert.Equal(t, actions, iam.Actions(), "failed %s", failed)
+		assert.Equal(t, "flavor-s2.large.4", driver.FlavorID)
+
+		st, err := driver.GetState()
+		assert.NoError(t, err)
+		assert.Equal(t, state.Running, st, "failed %s", failed)
+		iam.Close()
+	}
+}
+
+func TestRunCommand_Resize(t *testing.T) {
+	iam := newResizeIAM()
+	defer iam.Close()
+
+	storePath, err := ioutil.TempDir("", "otc-store")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(storePath) }()
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	driver.FlavorName = "s2.large.4"
+	driver.FlavorID = "flavor-s2.large.4"
+	driver.PollIntervals = []string{"10ms"}
+	driverData, err := json.Marshal(driver)
+	require.NoError(t, err)
+	configPath := filepath.Join(storePath, "machines", instanceName, "config.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(configPath), 0700))
+	config, err := json.Marshal(map[string]interface{}{
+		"ConfigVersion": 3,
+		"Driver":        json.RawMessage(driverData),
+		"DriverName":    driverName,
+		"Name":          instanceName,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(configPath, config, 0600))
+
+	assert.Error(t, RunCommand(nil))
+	assert.Error(t, RunCommand([]string{"unknown"}))
+	assert.Error(t, RunCommand([]string{"resize", "--storage-path", storePath, instanceName}))
+	require.NoError(t, RunCommand([]string{"resize", "--storage-path", storePath, instanceName, "s2.xlarge.4"}))
+
+	data, err := ioutil.ReadFile(configPath)
+	require.NoError(t, err)
+	saved := struct {
+		ConfigVersion int
+		Name          string
+		Driver        *Driver
+	}{Driver: NewDriver("", "")}
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, 3, saved.ConfigVersion)
+	assert.Equal(t, instanceName, saved.Name)
+	assert.Equal(t, "s2.xlarge.4", saved.Driver.FlavorName)
+	assert.Equal(t, "flavor-s2.xlarge.4", saved.Driver.FlavorID)
+	assert.Equal(t, "instance", saved.Driver.InstanceID)
+}
@@ -0,0 +1,151 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// retryPolicy defines how throttled and failed API requests are repeated
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// MaxRetryAfter limits delay requested by server with `Retry-After` header
+	MaxRetryAfter time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	MaxRetries:    5,
+	BaseDelay:     500 * time.Millisecond,
+	MaxDelay:      30 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff returns randomized exponential delay before the retry with given number
+func (p retryPolicy) backoff(retry int) time.Duration {
+	delay := p.MaxDelay
+	// large shifts overflow
+	if retry < 30 {
+		if d := p.BaseDelay << uint(retry); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	// "full jitter": spread retries of concurrent clients over the whole interval
+	return time.Duration(jitter.Int63n(int64(delay) + 1))
+}
+
+// retryAfter parses `Retry-After` header, which is either number of seconds or HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry checks if request can be repeated safely after given response or error
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return isIdempotent(req.Method) && req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		// request is rejected before processing
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return isIdempotent(req.Method)
+	}
+	return false
+}
+
+// retryTransport repeats throttled requests and requests failed with transient errors
+type retryTransport struct {
+	base   http.RoundTripper
+	policy retryPolicy
+}
+
+func newRetryTransport(base http.RoundTripper, policy retryPolicy) *retryTransport {
+	return &retryTransport{base: base, policy: policy}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	for retry := 0; ; retry++ {
+		r := req
+		if retry > 0 {
+			var err error
+			if r, err = cloneRequest(req, true); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.base.RoundTrip(r)
+		if retry >= t.policy.MaxRetries || !replayable || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(retry)
+		if err != nil {
+			log.Debugf("Request %s %s failed: %s, retrying in %s", req.Method, req.URL.Path, err, delay)
+		} else {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+				if delay > t.policy.MaxRetryAfter {
+					delay = t.policy.MaxRetryAfter
+				}
+			}
+			log.Debugf("Request %s %s failed with status %d, retrying in %s",
+				req.Method, req.URL.Path, resp.StatusCode, delay)
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for the delay, returning early with context error if the context is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,198 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetryPolicy = retryPolicy{
+	MaxRetries:    3,
+	BaseDelay:     time.Millisecond,
+	MaxDelay:      10 * time.Millisecond,
+	MaxRetryAfter: 2 * time.Second,
+}
+
+type fault struct {
+	status     int
+	retryAfter string
+}
+
+// faultServer is a fault-injecting server, it returns given faults before successful responses
+type faultServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	faults []fault
+	bodies []string
+}
+
+func newFaultServer(faults ...fault) *faultServer {
+	srv := &faultServer{faults: faults}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.handle))
+	return srv
+}
+
+// Bodies returns bodies of all received requests
+func (s *faultServer) Bodies() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func (s *faultServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, string(body))
+	if len(s.faults) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	f := s.faults[0]
+	s.faults = s.faults[1:]
+	if f.retryAfter != "" {
+		w.Header().Set("Retry-After", f.retryAfter)
+	}
+	w.WriteHeader(f.status)
+}
+
+func doRequest(t *testing.T, method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: newRetryTransport(defaultTransport, testRetryPolicy)}).Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp
+}
+
+func TestRetry_Throttled(t *testing.T) {
+	srv := newFaultServer(
+		fault{status: http.StatusTooManyRequests},
+		fault{status: http.StatusServiceUnavailable},
+		fault{status: http.StatusTooManyRequests},
+	)
+	defer srv.Close()
+
+	resp := doRequest(t, http.MethodPost, srv.URL, "create")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// body is sent again with every retry
+	assert.Equal(t, []string{"create", "create", "create", "create"}, srv.Bodies())
+}
+
+func TestRetry_ServerError(t *testing.T) {
+	srv := newFaultServer(fault{status: http.StatusBadGateway}, fault{status: http.StatusInternalServerError})
+	defer srv.Close()
+
+	resp := doRequest(t, http.MethodGet, srv.URL, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, srv.Bodies(), 3)
+}
+
+func TestRetry_NotIdempotent(t *testing.T) {
+	srv := newFaultServer(fault{status: http.StatusBadGateway})
+	defer srv.Close()
+
+	resp := doRequest(t, http.MethodPost, srv.URL, "create")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Len(t, srv.Bodies(), 1)
+}
+
+func TestRetry_ClientError(t *testing.T) {
+	srv := newFaultServer(fault{status: http.StatusBadRequest})
+	defer srv.Close()
+
+	resp := doRequest(t, http.MethodGet, srv.URL, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Len(t, srv.Bodies(), 1)
+}
+
+func TestRetry_Exhausted(t *testing.T) {
+	faults := make([]fault, testRetryPolicy.MaxRetries+2)
+	for i := range faults {
+		faults[i] = fault{status: http.StatusTooManyRequests}
+	}
+	srv := newFaultServer(faults...)
+	defer srv.Close()
+
+	resp := doRequest(t, http.MethodGet, srv.URL, "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Len(t, srv.Bodies(), testRetryPolicy.MaxRetries+1)
+}
+
+func TestRetry_RetryAfter(t *testing.T) {
+	srv := newFaultServer(fault{status: http.StatusTooManyRequests, retryAfter: "1"})
+	defer srv.Close()
+
+	start := time.Now()
+	resp := doRequest(t, http.MethodGet, srv.URL, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, time.Since(start) >= time.Second, "Retry-After is ignored")
+
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	delay, ok := retryAfter(&http.Response{Header: http.Header{"Retry-After": {date}}})
+	require.True(t, ok)
+	assert.True(t, delay > 59*time.Minute)
+}
+
+func TestRetry_Cancelled(t *testing.T) {
+	srv := newFaultServer(fault{status: http.StatusTooManyRequests, retryAfter: "120"})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	policy := testRetryPolicy
+	policy.MaxRetryAfter = 2 * time.Minute
+	start := time.Now()
+	_, err = (&http.Client{Transport: newRetryTransport(defaultTransport, policy)}).Do(req.WithContext(ctx))
+	require.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second, "retry delay is not interrupted")
+	assert.Len(t, srv.Bodies(), 1)
+}
+
+func TestRetry_Backoff(t *testing.T) {
+	policy := retryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for retry := 0; retry < 10; retry++ {
+		delay := policy.backoff(retry)
+		assert.True(t, delay >= 0)
+		assert.True(t, delay <= policy.MaxDelay)
+		assert.True(t, delay <= policy.BaseDelay<<uint(retry))
+	}
+	// shift overflow
+	assert.True(t, policy.backoff(100) <= policy.MaxDelay)
+}
+
+func TestDriver_Retry(t *testing.T) {
+	policy := defaultRetryPolicy
+	defaultRetryPolicy = testRetryPolicy
+	defer func() { defaultRetryPolicy = policy }()
+
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newTLSDriver(iam)
+	require.NoError(t, driver.initCompute())
+	iam.compute.injectFaults(http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout)
+	server, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Equal(t, "ACTIVE", server.Status)
+
+	iam.compute.injectFaults(http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests,
+		http.StatusTooManyRequests)
+	_, err = driver.client.GetInstanceStatus("instance")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Too many requests")
+}
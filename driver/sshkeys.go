@@ -0,0 +1,281 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"github.com/docker/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Supported SSH key types
+const (
+	sshKeyTypeRSA     = "rsa"
+	sshKeyTypeECDSA   = "ecdsa"
+	sshKeyTypeEd25519 = "ed25519"
+
+	defaultSSHKeyType = sshKeyTypeRSA
+	defaultRSABits    = 2048
+	defaultECDSABits  = 256
+	minRSABits        = 2048
+	// maxRSABits is the limit of ssh-keygen, larger keys take hours to generate
+	maxRSABits = 16384
+)
+
+var ecdsaCurves = map[int]elliptic.Curve{
+	256: elliptic.P256(),
+	384: elliptic.P384(),
+	521: elliptic.P521(),
+}
+
+func checkSSHKeyOpts(keyType string, bits int) error {
+	switch keyType {
+	case "", sshKeyTypeRSA:
+		if bits != 0 && (bits < minRSABits || bits > maxRSABits) {
+			return fmt.Errorf("RSA key size must be from %d to %d bits, got %d", minRSABits, maxRSABits, bits)
+		}
+	case sshKeyTypeECDSA:
+		if _, ok := ecdsaCurves[bits]; bits != 0 && !ok {
+			return fmt.Errorf("ECDSA key size must be one of 256, 384, 521 bits, got %d", bits)
+		}
+	case sshKeyTypeEd25519:
+	default:
+		return fmt.Errorf("unsupported SSH key type `%s`, expected one of %s, %s, %s",
+			keyType, sshKeyTypeRSA, sshKeyTypeECDSA, sshKeyTypeEd25519)
+	}
+	return nil
+}
+
+// newSSHKey generates private key of given type and size
+func newSSHKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "", sshKeyTypeRSA:
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case sshKeyTypeECDSA:
+		if bits == 0 {
+			bits = defaultECDSABits
+		}
+		return ecdsa.GenerateKey(ecdsaCurves[bits], rand.Reader)
+	case sshKeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported SSH key type `%s`", keyType)
+	}
+}
+
+// marshalSSHPrivateKey encodes private key to PEM format readable by both OpenSSH and docker-machine
+func marshalSSHPrivateKey(key crypto.Signer) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case ed25519.PrivateKey:
+		return marshalOpenSSHEd25519(k)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// marshalOpenSSHEd25519 encodes ed25519 key in `openssh-key-v1` format,
+// the only format OpenSSH accepts for ed25519 keys
+func marshalOpenSSHEd25519(key ed25519.PrivateKey) ([]byte, error) {
+	pub, err := gossh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+	checkInt := binary.BigEndian.Uint32(check)
+
+	privKey := struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+		Pad     []byte `ssh:"rest"`
+	}{
+		Check1:  checkInt,
+		Check2:  checkInt,
+		Keytype: gossh.KeyAlgoED25519,
+		Pub:     []byte(key.Public().(ed25519.PublicKey)),
+		Priv:    []byte(key),
+	}
+	// private section is padded to the cipher block size, 8 for `none`
+	padLen := (8 - len(gossh.Marshal(privKey))%8) % 8
+	for i := 1; i <= padLen; i++ {
+		privKey.Pad = append(privKey.Pad, byte(i))
+	}
+
+	envelope := struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{
+		CipherName:   "none",
+		KdfName:      "none",
+		NumKeys:      1,
+		PubKey:       pub.Marshal(),
+		PrivKeyBlock: gossh.Marshal(privKey),
+	}
+	data := append([]byte("openssh-key-v1\x00"), gossh.Marshal(envelope)...)
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: data}), nil
+}
+
+// generateSSHKey generates SSH key pair of given type, private key is written to `path`
+// and public key is written to `path.pub`. Existing key is not overwritten.
+func generateSSHKey(path, keyType string, bits int) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("desired directory for SSH keys does not exist: %s", err)
+	}
+
+	key, err := newSSHKey(keyType, bits)
+	if err != nil {
+		return fmt.Errorf("error generating key pair: %s", err)
+	}
+	privateKey, err := marshalSSHPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	publicKey, err := gossh.NewPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, privateKey, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".pub", gossh.MarshalAuthorizedKey(publicKey), 0600)
+}
+
+func (d *Driver) checkSSHKeyConfig() error {
+	if d.UseSSHAgent {
+		if d.PrivateKeyFile != "" {
+			return fmt.Errorf("private key file can't be used together with ssh-agent")
+		}
+		if d.KeyPairName.Value == "" && d.PublicKeyFile == "" {
+			return fmt.Errorf("either key pair name or public key file is required for using ssh-agent")
+		}
+		return nil
+	}
+	if d.KeyPairName.Value != "" && d.PrivateKeyFile == "" {
+		return fmt.Errorf(errorBothOptions, "KeyPairName", "PrivateKeyFile")
+	}
+	if d.PublicKeyFile != "" && d.PrivateKeyFile == "" {
+		return fmt.Errorf(errorBothOptions, "PublicKeyFile", "PrivateKeyFile")
+	}
+	return nil
+}
+
+func (d *Driver) readPublicKeyFile() ([]byte, error) {
+	publicKey, err := ioutil.ReadFile(d.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, _, err := gossh.ParseAuthorizedKey(publicKey); err != nil {
+		return nil, fmt.Errorf("invalid public key in %s: %s", d.PublicKeyFile, err)
+	}
+	return publicKey, nil
+}
+
+// readPublicKey returns public key from `PublicKeyFile` or derives it from the private key
+func (d *Driver) readPublicKey(privateKey []byte) ([]byte, error) {
+	if d.PublicKeyFile != "" {
+		return d.readPublicKeyFile()
+	}
+	signer, err := gossh.ParsePrivateKey(privateKey)
+	if err != nil {
+		if _, ok := err.(*gossh.PassphraseMissingError); ok {
+			return nil, fmt.Errorf("private key %s is encrypted, use `-otc-public-key-file` to provide public key", d.PrivateKeyFile)
+		}
+		return nil, err
+	}
+	return gossh.MarshalAuthorizedKey(signer.PublicKey()), nil
+}
+
+// checkAgentKey makes sure that key is held by ssh-agent listening on SSH_AUTH_SOCK
+func checkAgentKey(publicKey []byte) error {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return err
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return fmt.Errorf("SSH_AUTH_SOCK is not set, ssh-agent is not running")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to ssh-agent: %s", err)
+	}
+	defer conn.Close()
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if bytes.Equal(key.Marshal(), pub.Marshal()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("key %s is not loaded to ssh-agent", gossh.FingerprintSHA256(pub))
+}
+
+// loadAgentKey makes sure key pair public key is held by ssh-agent, no keys are written to the machine store
+func (d *Driver) loadAgentKey() error {
+	if d.KeyPairName.Value == "" {
+		d.KeyPairName = managedSting{d.newKeyPairName(), true}
+	}
+	log.Debug("Loading Key Pair for ssh-agent", d.KeyPairName.Value)
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+	publicKey, err := d.ensureKeyPair(func() ([]byte, error) {
+		publicKey, err := d.readPublicKeyFile()
+		if err != nil {
+			return nil, err
+		}
+		// key which can't be used for SSH shouldn't be uploaded
+		return publicKey, checkAgentKey(publicKey)
+	})
+	if err != nil {
+		return err
+	}
+	return checkAgentKey(publicKey)
+}
+
+// GetSSHKeyPath returns empty path when ssh-agent is used, so libmachine relies on the agent
+func (d *Driver) GetSSHKeyPath() string {
+	if d.UseSSHAgent {
+		return ""
+	}
+	return d.BaseDriver.GetSSHKeyPath()
+}
@@ -0,0 +1,253 @@
+package opentelekomcloud
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestGenerateSSHKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dmd-keys")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	cases := []struct {
+		keyType string
+		bits    int
+		algo    string
+	}{
+		{sshKeyTypeRSA, 0, gossh.KeyAlgoRSA},
+		{sshKeyTypeRSA, 3072, gossh.KeyAlgoRSA},
+		{sshKeyTypeECDSA, 0, gossh.KeyAlgoECDSA256},
+		{sshKeyTypeECDSA, 384, gossh.KeyAlgoECDSA384},
+		{sshKeyTypeEd25519, 0, gossh.KeyAlgoED25519},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, c.algo)
+		require.NoError(t, generateSSHKey(path, c.keyType, c.bits))
+
+		privateKey, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		signer, err := gossh.ParsePrivateKey(privateKey)
+		require.NoError(t, err, c.keyType)
+
+		publicKey, err := ioutil.ReadFile(path + ".pub")
+		require.NoError(t, err)
+		pub, _, _, _, err := gossh.ParseAuthorizedKey(publicKey)
+		require.NoError(t, err)
+
+		assert.Equal(t, c.algo, pub.Type())
+		assert.Equal(t, pub.Marshal(), signer.PublicKey().Marshal())
+	}
+}
+
+func TestDriver_SSHKeyTypeConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	cases := []struct {
+		keyType string
+		bits    int
+		valid   bool
+	}{
+		{"ed25519", 0, true},
+		{"ecdsa", 521, true},
+		{"ecdsa", 1024, false},
+		{"rsa", 1024, false},
+		{"rsa", 16384, true},
+		{"rsa", 1000000, false},
+		{"dsa", 0, false},
+	}
+	for _, c := range cases {
+		driver := NewDriver(instanceName, storePath)
+		flags := &drivers.CheckDriverOptions{
+			FlagsValues: map[string]interface{}{
+				"otc-cloud":        "otc",
+				"otc-ssh-key-type": c.keyType,
+				"otc-ssh-key-bits": c.bits,
+			},
+			CreateFlags: driver.GetCreateFlags(),
+		}
+		err := driver.SetConfigFromFlags(flags)
+		if c.valid {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+}
+
+func newFakeDriver(t *testing.T) (*Driver, *fakeClient) {
+	storePath, err := ioutil.TempDir("", "dmd-store")
+	require.NoError(t, err)
+	driver := NewDriver(instanceName, storePath)
+	require.NoError(t, os.MkdirAll(driver.ResolveStorePath(""), 0700))
+	client := newFakeClient()
+	driver.client = client
+	driver.provider = fakeProvider("http://compute.invalid/v2.1/project/")
+	return driver, client
+}
+
+func TestDriver_UploadPrivateKey(t *testing.T) {
+	driver, client := newFakeDriver(t)
+	defer func() {
+		_ = os.RemoveAll(driver.StorePath)
+	}()
+
+	keyPath := filepath.Join(driver.StorePath, "user_key")
+	require.NoError(t, generateSSHKey(keyPath, sshKeyTypeEd25519, 0))
+	expectedPublicKey, err := ioutil.ReadFile(keyPath + ".pub")
+	require.NoError(t, err)
+	driver.PrivateKeyFile = keyPath
+
+	require.NoError(t, driver.loadSSHKey())
+	assert.NotEmpty(t, driver.KeyPairName.Value)
+	assert.True(t, driver.KeyPairName.DriverManaged)
+	assert.Equal(t, string(expectedPublicKey), client.keyPairs[driver.KeyPairName.Value])
+
+	storedKey, err := ioutil.ReadFile(driver.GetSSHKeyPath() + ".pub")
+	require.NoError(t, err)
+	assert.Equal(t, expectedPublicKey, storedKey)
+}
+
+func TestDriver_UploadPublicKeyFile(t *testing.T) {
+	driver, client := newFakeDriver(t)
+	defer func() {
+		_ = os.RemoveAll(driver.StorePath)
+	}()
+
+	keyPath := filepath.Join(driver.StorePath, "user_key")
+	require.NoError(t, generateSSHKey(keyPath, sshKeyTypeRSA, 0))
+	publicKey, err := ioutil.ReadFile(keyPath + ".pub")
+	require.NoError(t, err)
+	publicKeyPath := filepath.Join(driver.StorePath, "other.pub")
+	require.NoError(t, ioutil.WriteFile(publicKeyPath, publicKey, 0600))
+
+	driver.PrivateKeyFile = keyPath
+	driver.PublicKeyFile = publicKeyPath
+
+	require.NoError(t, driver.loadSSHKey())
+	assert.True(t, driver.KeyPairName.DriverManaged)
+	assert.Equal(t, string(publicKey), client.keyPairs[driver.KeyPairName.Value])
+}
+
+func TestDriver_MissingKeyPairNotCreated(t *testing.T) {
+	driver, client := newFakeDriver(t)
+	defer func() {
+		_ = os.RemoveAll(driver.StorePath)
+	}()
+
+	keyPath := filepath.Join(driver.StorePath, "user_key")
+	require.NoError(t, generateSSHKey(keyPath, sshKeyTypeRSA, 0))
+
+	driver.KeyPairName = managedSting{Value: "user-kp"}
+	driver.PrivateKeyFile = keyPath
+	err := driver.loadSSHKey()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "user-kp")
+	assert.False(t, driver.KeyPairName.DriverManaged)
+	assert.Empty(t, client.keyPairs)
+}
+
+func TestDriver_ExistingKeyPairNotManaged(t *testing.T) {
+	driver, client := newFakeDriver(t)
+	defer func() {
+		_ = os.RemoveAll(driver.StorePath)
+	}()
+
+	keyPath := filepath.Join(driver.StorePath, "user_key")
+	require.NoError(t, generateSSHKey(keyPath, sshKeyTypeECDSA, 0))
+	publicKey, err := ioutil.ReadFile(keyPath + ".pub")
+	require.NoError(t, err)
+	_, err = client.CreateKeyPair("existing-kp", string(publicKey))
+	require.NoError(t, err)
+
+	driver.KeyPairName = managedSting{Value: "existing-kp"}
+	driver.PrivateKeyFile = keyPath
+	require.NoError(t, driver.loadSSHKey())
+	assert.False(t, driver.KeyPairName.DriverManaged)
+}
+
+// startAgent serves in-memory ssh-agent on unix socket and sets SSH_AUTH_SOCK
+func startAgent(t *testing.T, dir string) (agent.Agent, func()) {
+	keyring := agent.NewKeyring()
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	oldSocket := os.Getenv("SSH_AUTH_SOCK")
+	require.NoError(t, os.Setenv("SSH_AUTH_SOCK", socket))
+	return keyring, func() {
+		_ = listener.Close()
+		_ = os.Setenv("SSH_AUTH_SOCK", oldSocket)
+	}
+}
+
+func TestDriver_SSHAgent(t *testing.T) {
+	driver, client := newFakeDriver(t)
+	defer func() {
+		_ = os.RemoveAll(driver.StorePath)
+	}()
+	keyring, stopAgent := startAgent(t, driver.StorePath)
+	defer stopAgent()
+
+	key, err := newSSHKey(sshKeyTypeEd25519, 0)
+	require.NoError(t, err)
+	publicKey, err := gossh.NewPublicKey(key.Public())
+	require.NoError(t, err)
+	publicKeyPath := filepath.Join(driver.StorePath, "agent.pub")
+	require.NoError(t, ioutil.WriteFile(publicKeyPath, gossh.MarshalAuthorizedKey(publicKey), 0600))
+
+	driver.UseSSHAgent = true
+	driver.PublicKeyFile = publicKeyPath
+	require.NoError(t, driver.checkSSHKeyConfig())
+
+	// key is not in agent yet, so it's not uploaded
+	assert.Error(t, driver.loadAgentKey())
+	assert.Empty(t, client.keyPairs)
+
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+	require.NoError(t, driver.loadAgentKey())
+	assert.True(t, driver.KeyPairName.DriverManaged)
+	assert.Equal(t, string(gossh.MarshalAuthorizedKey(publicKey)), client.keyPairs[driver.KeyPairName.Value])
+
+	assert.Empty(t, driver.GetSSHKeyPath())
+	files, err := ioutil.ReadDir(driver.ResolveStorePath(""))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestDriver_SSHAgentConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.UseSSHAgent = true
+	assert.Error(t, driver.checkSSHKeyConfig())
+
+	driver.KeyPairName = managedSting{Value: "kp"}
+	assert.NoError(t, driver.checkSSHKeyConfig())
+
+	driver.PrivateKeyFile = "id_rsa"
+	assert.Error(t, driver.checkSSHKeyConfig())
+}
@@ -0,0 +1,158 @@
+package opentelekomcloud
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const stateFileName = "otc-resources.json"
+
+// createdResources are saved during machine creation, so resources can be removed even if
+// docker-machine hasn't saved machine config after interrupted or failed creation
+type createdResources struct {
+	InstanceID             string       `json:"instance_id,omitempty"`
+	KeyPairName            managedSting `json:"key_pair"`
+	VpcID                  managedSting `json:"vpc_id"`
+	SubnetID               managedSting `json:"subnet_id"`
+	FloatingIP             managedSting `json:"floating_ip"`
+	ManagedSecurityGroupID string       `json:"managed_security_group,omitempty"`
+	K8sSecurityGroupID     string       `json:"k8s_security_group,omitempty"`
+}
+
+func (d *Driver) statePath() string {
+	if d.BaseDriver == nil || d.StorePath == "" {
+		return ""
+	}
+	return d.ResolveStorePath(stateFileName)
+}
+
+func (d *Driver) createdResources() *createdResources {
+	return &createdResources{
+		InstanceID:             d.InstanceID,
+		KeyPairName:            d.KeyPairName,
+		VpcID:                  d.VpcID,
+		SubnetID:               d.SubnetID,
+		FloatingIP:             d.FloatingIP,
+		ManagedSecurityGroupID: d.ManagedSecurityGroupID,
+		K8sSecurityGroupID:     d.K8sSecurityGroupID,
+	}
+}
+
+func (d *Driver) saveState() error {
+	return d.writeState(d.createdResources())
+}
+
+func (d *Driver) writeState(state *createdResources) error {
+	path := d.statePath()
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// recordStep copies resources created by the step to the state
+func (d *Driver) recordStep(step string, state *createdResources) {
+	switch step {
+	case stepVPC:
+		state.VpcID = d.VpcID
+	case stepSubnet:
+		state.SubnetID = d.SubnetID
+	case stepSecGroup:
+		state.ManagedSecurityGroupID = d.ManagedSecurityGroupID
+	case stepK8sSecGroup:
+		state.K8sSecurityGroupID = d.K8sSecurityGroupID
+	case stepKeyPair:
+		state.KeyPairName = d.KeyPairName
+	case stepEIP:
+		state.FloatingIP = d.FloatingIP
+	case stepInstance:
+		state.InstanceID = d.InstanceID
+	}
+}
+
+// checkpointSteps makes every step save created resources as soon as it ends, so the resources
+// can be removed even if the process is killed in the middle of creation.
+// Only resources of the finished step are read, as other steps may be still running.
+func (d *Driver) checkpointSteps(steps []graphStep) []graphStep {
+	state := d.createdResources()
+	mu := &sync.Mutex{}
+	wrapped := make([]graphStep, len(steps))
+	for i, step := range steps {
+		run, name := step.Run, step.Name
+		step.Run = func() error {
+			err := run()
+			mu.Lock()
+			defer mu.Unlock()
+			d.recordStep(name, state)
+			if saveErr := d.writeState(state); saveErr != nil {
+				log.Warnf("Failed to save created resources: %s", saveErr)
+			}
+			return err
+		}
+		wrapped[i] = step
+	}
+	return wrapped
+}
+
+func restoreManaged(current *managedSting, saved managedSting) {
+	if current.Value == "" && saved.DriverManaged {
+		*current = saved
+	}
+}
+
+// restoreState sets IDs of created resources missing in machine config
+func (d *Driver) restoreState() error {
+	path := d.statePath()
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	saved := &createdResources{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return err
+	}
+	if d.InstanceID == "" && saved.InstanceID != "" {
+		log.Debugf("Instance `%s` is restored from %s", saved.InstanceID, path)
+		d.InstanceID = saved.InstanceID
+	}
+	restoreManaged(&d.KeyPairName, saved.KeyPairName)
+	restoreManaged(&d.VpcID, saved.VpcID)
+	restoreManaged(&d.SubnetID, saved.SubnetID)
+	restoreManaged(&d.FloatingIP, saved.FloatingIP)
+	if d.ManagedSecurityGroupID == "" {
+		d.ManagedSecurityGroupID = saved.ManagedSecurityGroupID
+	}
+	if d.K8sSecurityGroupID == "" {
+		d.K8sSecurityGroupID = saved.K8sSecurityGroupID
+	}
+	return nil
+}
+
+func (d *Driver) removeState() error {
+	path := d.statePath()
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
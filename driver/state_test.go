@@ -0,0 +1,81 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_SaveState(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "otc-state")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(storePath) }()
+
+	driver := NewDriver(instanceName, storePath)
+	driver.InstanceID = "instance"
+	driver.VpcID = managedSting{Value: "vpc", DriverManaged: true}
+	driver.SubnetID = managedSting{Value: "subnet"}
+	driver.KeyPairName = managedSting{Value: "key", DriverManaged: true}
+	driver.ManagedSecurityGroupID = "sg"
+	require.NoError(t, driver.saveState())
+
+	info, err := os.Stat(driver.ResolveStorePath(stateFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// machine config saved before creation has no resource IDs
+	restored := NewDriver(instanceName, storePath)
+	restored.SubnetID = managedSting{Value: "existing"}
+	require.NoError(t, restored.restoreState())
+	assert.Equal(t, "instance", restored.InstanceID)
+	assert.Equal(t, driver.VpcID, restored.VpcID)
+	assert.Equal(t, driver.KeyPairName, restored.KeyPairName)
+	assert.Equal(t, "existing", restored.SubnetID.Value)
+	assert.Equal(t, "sg", restored.ManagedSecurityGroupID)
+	assert.Empty(t, restored.K8sSecurityGroupID)
+
+	require.NoError(t, restored.removeState())
+	require.NoError(t, restored.removeState())
+	empty := NewDriver(instanceName, storePath)
+	require.NoError(t, empty.restoreState())
+	assert.Empty(t, empty.InstanceID)
+}
+
+func TestDriver_CheckpointSteps(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "otc-state")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(storePath) }()
+
+	driver := NewDriver(instanceName, storePath)
+	steps := []graphStep{
+		{Name: stepVPC, Run: func() error {
+			driver.VpcID = managedSting{Value: "vpc", DriverManaged: true}
+			return nil
+		}},
+		{Name: stepInstance, After: []string{stepVPC}, Run: func() error {
+			// resources of finished steps are saved while the creation goes on
+			saved := NewDriver(instanceName, storePath)
+			require.NoError(t, saved.restoreState())
+			assert.Equal(t, "vpc", saved.VpcID.Value)
+			driver.InstanceID = "instance"
+			return fmt.Errorf("instance is not active")
+		}},
+	}
+	require.Error(t, runGraph(context.Background(), driver.checkpointSteps(steps)))
+
+	saved := NewDriver(instanceName, storePath)
+	require.NoError(t, saved.restoreState())
+	assert.Equal(t, "instance", saved.InstanceID, "resource of failed step is saved")
+}
+
+// tempStorePath returns temporary machine store removed by returned function
+func tempStorePath(t *testing.T) (string, func()) {
+	storePath, err := ioutil.TempDir("", "otc-store")
+	require.NoError(t, err)
+	return storePath, func() { _ = os.RemoveAll(storePath) }
+}
@@ -0,0 +1,120 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/servers"
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+)
+
+// InstanceDeletedError is returned when machine instance doesn't exist anymore,
+// e.g. it was deleted outside of docker-machine
+type InstanceDeletedError struct {
+	InstanceID string
+}
+
+func (e *InstanceDeletedError) Error() string {
+	return fmt.Sprintf("instance `%s` doesn't exist, it has been deleted outside of docker-machine", e.InstanceID)
+}
+
+// Power states reported in `OS-EXT-STS:power_state`
+const (
+	powerStateNone    = 0
+	powerStateRunning = 1
+)
+
+// instanceStatus is ECS status together with the current task
+type instanceStatus struct {
+	Status     string `json:"status"`
+	TaskState  string `json:"OS-EXT-STS:task_state"`
+	PowerState int    `json:"OS-EXT-STS:power_state"`
+	// AvailabilityZone is reported only by compute API
+	AvailabilityZone string `json:"OS-EXT-AZ:availability_zone"`
+	// VolumesAttached include system volume of the instance
+	VolumesAttached []attachedVolume `json:"os-extended-volumes:volumes_attached"`
+}
+
+type attachedVolume struct {
+	ID string `json:"id"`
+}
+
+func (d *Driver) getInstanceStatus() (*instanceStatus, error) {
+	status := &instanceStatus{}
+	err := servers.Get(d.computeV2, d.InstanceID).ExtractIntoStructPtr(status, "server")
+	if _, ok := err.(golangsdk.ErrDefault404); ok {
+		return nil, &InstanceDeletedError{InstanceID: d.InstanceID}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// transitional task states of running and stopped instances
+var (
+	stoppingTasks = map[string]bool{
+		"powering-off": true,
+		"deleting":     true,
+		"shelving":     true,
+		"suspending":   true,
+		"pausing":      true,
+	}
+	startingTasks = map[string]bool{
+		"powering-on":         true,
+		"rebooting":           true,
+		"reboot_pending":      true,
+		"reboot_started":      true,
+		"rebooting_hard":      true,
+		"reboot_pending_hard": true,
+		"reboot_started_hard": true,
+		"rebuilding":          true,
+		"unshelving":          true,
+		"resuming":            true,
+		"unpausing":           true,
+	}
+)
+
+// machineState maps ECS status to libmachine state
+func (s *instanceStatus) machineState(instanceID string) (state.State, error) {
+	switch s.Status {
+	case services.InstanceStatusRunning, services.InstanceStatusStopped:
+		switch {
+		case stoppingTasks[s.TaskState]:
+			return state.Stopping, nil
+		case startingTasks[s.TaskState]:
+			return state.Starting, nil
+		case s.Status == services.InstanceStatusRunning:
+			return state.Running, nil
+		default:
+			return state.Stopped, nil
+		}
+	case "BUILD", "BUILDING", "REBUILD", "REBOOT", "HARD_REBOOT":
+		return state.Starting, nil
+	case "RESIZE", "VERIFY_RESIZE", "REVERT_RESIZE", "MIGRATING":
+		// instance keeps running during live migration and is started after resize if it was running
+		switch s.PowerState {
+		case powerStateRunning:
+			return state.Running, nil
+		case powerStateNone:
+			return state.Starting, nil
+		default:
+			return state.Stopped, nil
+		}
+	case "PAUSED":
+		return state.Paused, nil
+	case "SUSPENDED":
+		return state.Saved, nil
+	case "SHELVED", "SHELVED_OFFLOADED":
+		return state.Stopped, nil
+	case "RESCUE", "ERROR":
+		return state.Error, nil
+	case "DELETED", "SOFT_DELETED":
+		return state.None, &InstanceDeletedError{InstanceID: instanceID}
+	default:
+		log.Debugf("Unknown instance status `%s`", s.Status)
+		return state.None, nil
+	}
+}
@@ -0,0 +1,69 @@
+package opentelekomcloud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceStatus_MachineState(t *testing.T) {
+	cases := []struct {
+		status   instanceStatus
+		expected state.State
+	}{
+		{instanceStatus{Status: "ACTIVE"}, state.Running},
+		{instanceStatus{Status: "ACTIVE", TaskState: "powering-off"}, state.Stopping},
+		{instanceStatus{Status: "ACTIVE", TaskState: "rebooting"}, state.Starting},
+		{instanceStatus{Status: "SHUTOFF"}, state.Stopped},
+		{instanceStatus{Status: "SHUTOFF", TaskState: "powering-on"}, state.Starting},
+		{instanceStatus{Status: "BUILD"}, state.Starting},
+		{instanceStatus{Status: "REBOOT"}, state.Starting},
+		{instanceStatus{Status: "HARD_REBOOT"}, state.Starting},
+		{instanceStatus{Status: "REBUILD"}, state.Starting},
+		{instanceStatus{Status: "MIGRATING", PowerState: powerStateRunning}, state.Running},
+		{instanceStatus{Status: "RESIZE", PowerState: 4}, state.Stopped},
+		{instanceStatus{Status: "RESIZE"}, state.Starting},
+		{instanceStatus{Status: "VERIFY_RESIZE", PowerState: powerStateRunning}, state.Running},
+		{instanceStatus{Status: "PAUSED"}, state.Paused},
+		{instanceStatus{Status: "SUSPENDED"}, state.Saved},
+		{instanceStatus{Status: "SHELVED_OFFLOADED"}, state.Stopped},
+		{instanceStatus{Status: "ERROR"}, state.Error},
+		{instanceStatus{Status: "UNKNOWN"}, state.None},
+	}
+	for _, c := range cases {
+		st, err := c.status.machineState("instance")
+		require.NoError(t, err, c.status.Status)
+		assert.Equal(t, c.expected, st, "%s (%s)", c.status.Status, c.status.TaskState)
+	}
+
+	_, err := (&instanceStatus{Status: "DELETED"}).machineState("instance")
+	assert.IsType(t, &InstanceDeletedError{}, err)
+}
+
+func TestDriver_GetState(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	iam.compute.servers = map[string]*instanceStatus{
+		"rebooting": {Status: "HARD_REBOOT", PowerState: powerStateRunning},
+		"deleted":   nil,
+	}
+
+	driver := newTLSDriver(iam)
+	driver.InstanceID = "instance"
+	st, err := driver.GetState()
+	require.NoError(t, err)
+	assert.Equal(t, state.Running, st)
+
+	driver.InstanceID = "rebooting"
+	st, err = driver.GetState()
+	require.NoError(t, err)
+	assert.Equal(t, state.Starting, st)
+
+	driver.InstanceID = "deleted"
+	_, err = driver.GetState()
+	require.Error(t, err)
+	assert.Equal(t, &InstanceDeletedError{InstanceID: "deleted"}, err)
+}
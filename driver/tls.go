@@ -0,0 +1,76 @@
+package opentelekomcloud
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+)
+
+type tlsOptions struct {
+	CACertFile     string
+	ClientCertFile string
+	ClientKeyFile  string
+	Insecure       bool
+}
+
+// tlsOptions returns TLS settings of the driver, missing ones are taken from `clouds.yaml`
+func (d *Driver) tlsOptions() (*tlsOptions, error) {
+	opts := &tlsOptions{
+		CACertFile:     d.CACert,
+		ClientCertFile: d.ClientCert,
+		ClientKeyFile:  d.ClientKey,
+		Insecure:       d.Insecure,
+	}
+	if d.Cloud == "" {
+		return opts, nil
+	}
+	cloud, err := clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{Cloud: d.Cloud, YAMLOpts: d.yamlOpts()})
+	if err != nil {
+		return nil, err
+	}
+	if opts.CACertFile == "" {
+		opts.CACertFile = cloud.CACertFile
+	}
+	if opts.ClientCertFile == "" && opts.ClientKeyFile == "" {
+		opts.ClientCertFile = cloud.ClientCertFile
+		opts.ClientKeyFile = cloud.ClientKeyFile
+	}
+	if cloud.Verify != nil && !*cloud.Verify {
+		opts.Insecure = true
+	}
+	return opts, nil
+}
+
+// config returns nil if default TLS configuration should be used, the configuration is applied only to
+// the driver transport
+func (o *tlsOptions) config() (*tls.Config, error) {
+	if o.CACertFile == "" && o.ClientCertFile == "" && !o.Insecure {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: o.Insecure}
+	if o.CACertFile != "" {
+		pem, err := ioutil.ReadFile(o.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate bundle: %s", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCertFile, o.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,149 @@
+package opentelekomcloud
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, path, blockType string, bytes []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+}
+
+// newClientCert writes self-signed client certificate and its key to `dir`
+func newClientCert(t *testing.T, dir string) (certPath, keyPath string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "docker-machine"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath = filepath.Join(dir, "client.crt")
+	keyPath = filepath.Join(dir, "client.key")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return
+}
+
+func newTLSFakeIAM(t *testing.T, clientCA *x509.Certificate) (*fakeIAM, string) {
+	iam := newUnstartedFakeIAM(time.Minute)
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA)
+		iam.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	}
+	iam.StartTLS()
+
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	caPath := filepath.Join(dir, "ca.pem")
+	writePEM(t, caPath, "CERTIFICATE", iam.Certificate().Raw)
+	return iam, caPath
+}
+
+func newTLSDriver(iam *fakeIAM) *Driver {
+	driver := newIAMDriver(iam)
+	driver.Username = fakeUsername
+	driver.Password = fakePassword
+	return driver
+}
+
+func TestDriver_TLS(t *testing.T) {
+	iam, caPath := newTLSFakeIAM(t, nil)
+	defer iam.Close()
+	defer func() { _ = os.RemoveAll(filepath.Dir(caPath)) }()
+
+	driver := newTLSDriver(iam)
+	require.Error(t, driver.Authenticate(), "unknown CA should be rejected")
+
+	driver = newTLSDriver(iam)
+	driver.CACert = caPath
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+
+	driver = newTLSDriver(iam)
+	driver.Insecure = true
+	require.NoError(t, driver.Authenticate())
+
+	// TLS settings are applied only to the driver transport
+	_, err = http.Get(iam.URL)
+	assert.Error(t, err)
+	assert.Error(t, newTLSDriver(iam).Authenticate())
+}
+
+func TestDriver_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	certPath, keyPath, cert := newClientCert(t, dir)
+
+	iam, caPath := newTLSFakeIAM(t, cert)
+	defer iam.Close()
+	defer func() { _ = os.RemoveAll(filepath.Dir(caPath)) }()
+
+	driver := newTLSDriver(iam)
+	driver.CACert = caPath
+	require.Error(t, driver.Authenticate(), "client certificate is required")
+
+	driver = newTLSDriver(iam)
+	driver.CACert = caPath
+	driver.ClientCert = certPath
+	driver.ClientKey = keyPath
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+	_, err = driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+}
+
+func TestDriver_TLSConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-token":       "token",
+			"otc-client-cert": "client.crt",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	assert.Error(t, driver.SetConfigFromFlags(flags))
+
+	flags.FlagsValues["otc-client-key"] = "client.key"
+	flags.FlagsValues["otc-insecure"] = true
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	assert.False(t, driver.ValidateCert)
+
+	_, err := (&tlsOptions{CACertFile: "not-existing.pem"}).config()
+	assert.Error(t, err)
+}
@@ -0,0 +1,286 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack"
+	"github.com/opentelekomcloud-infra/crutch-house/clientconfig"
+)
+
+const (
+	authTokenHeader     = "X-Auth-Token"
+	securityTokenHeader = "X-Security-Token"
+)
+
+// defaultTransport is base for all driver transports
+var defaultTransport = http.DefaultTransport
+
+// contextTransport sends requests made without context, as all SDK requests are, with the driver context,
+// so interrupted operation cancels requests in flight together with their retries
+type contextTransport struct {
+	base http.RoundTripper
+	ctx  func() context.Context
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context() == context.Background() {
+		req = req.WithContext(t.ctx())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// tokenRefresher re-authenticates and repeats request once when API responds with 401
+// to the request made with expired token
+type tokenRefresher struct {
+	base   http.RoundTripper
+	reauth func() (string, error)
+
+	mu sync.Mutex
+	// tokens maps expired tokens to the token issued instead of them
+	tokens map[string]string
+}
+
+func newTokenRefresher(base http.RoundTripper, reauth func() (string, error)) *tokenRefresher {
+	return &tokenRefresher{
+		base:   base,
+		reauth: reauth,
+		tokens: make(map[string]string),
+	}
+}
+
+// currentToken returns token which should be used instead of the given one
+func (t *tokenRefresher) currentToken(token string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if current, ok := t.tokens[token]; ok {
+		return current
+	}
+	return token
+}
+
+// refresh issues new token only once for all requests failed with the same expired token
+func (t *tokenRefresher) refresh(expired string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if current, ok := t.tokens[expired]; ok {
+		return current, nil
+	}
+	token, err := t.reauth()
+	if err != nil {
+		return "", err
+	}
+	for old := range t.tokens {
+		t.tokens[old] = token
+	}
+	t.tokens[expired] = token
+	return token, nil
+}
+
+// cloneRequest returns shallow copy of request with own headers, body is rewound if it's possible
+func cloneRequest(req *http.Request, rewind bool) (*http.Request, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	if rewind && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+func withToken(req *http.Request, token string, rewind bool) (*http.Request, error) {
+	r, err := cloneRequest(req, rewind)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set(authTokenHeader, token)
+	return r, nil
+}
+
+func isAuthRequest(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/auth/tokens")
+}
+
+func (t *tokenRefresher) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := req.Header.Get(authTokenHeader)
+	if token == "" || isAuthRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	if current := t.currentToken(token); current != token {
+		r, err := withToken(req, current, false)
+		if err != nil {
+			return nil, err
+		}
+		req, token = r, current
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// request can't be repeated
+		return resp, nil
+	}
+
+	newToken, err := t.refresh(token)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	retry, err := withToken(req, newToken, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to repeat request with refreshed token: %s", err)
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// securityTokenSigner adds security token of temporary AK/SK to signed requests and signs them again,
+// as the token has to be one of signed headers
+type securityTokenSigner struct {
+	base          http.RoundTripper
+	accessKey     string
+	secretKey     string
+	securityToken string
+}
+
+func isSignedRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Authorization"), golangsdk.SignAlgorithmHMACSHA256)
+}
+
+func (s *securityTokenSigner) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isSignedRequest(req) {
+		return s.base.RoundTrip(req)
+	}
+	r, err := cloneRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set(securityTokenHeader, s.securityToken)
+	golangsdk.ReSign(r, golangsdk.SignOptions{
+		AccessKey: s.accessKey,
+		SecretKey: s.secretKey,
+	})
+	resp, err := s.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+	return nil, fmt.Errorf("temporary AK/SK credentials are rejected, most likely they have expired: " +
+		"request new AK/SK and security token")
+}
+
+// baseTransport returns own transport of the driver with TLS and proxy configuration applied
+func (d *Driver) baseTransport() (http.RoundTripper, error) {
+	tlsOpts, err := d.tlsOptions()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := (&proxyOptions{HTTPProxy: d.HTTPProxy, NoProxy: d.NoProxy}).proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	base, ok := defaultTransport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("can't configure %T", defaultTransport)
+	}
+	// the clone is configured, so settings of the driver don't affect other clients
+	transport := base.Clone()
+	if tlsConfig != nil {
+		if tlsConfig.InsecureSkipVerify {
+			log.Warn("TLS certificate verification is disabled, API connection is insecure")
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	transport.Proxy = proxy
+	return transport, nil
+}
+
+// newTransport builds transport chain of the driver HTTP client used by both IAM and service clients
+func (d *Driver) newTransport(opts *clientconfig.ClientOpts) (http.RoundTripper, error) {
+	base, err := d.baseTransport()
+	if err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper = newRetryTransport(base, defaultRetryPolicy)
+	if d.SecurityToken != "" {
+		transport = &securityTokenSigner{
+			base:          transport,
+			accessKey:     d.AccessKey,
+			secretKey:     d.SecretKey,
+			securityToken: d.SecurityToken,
+		}
+	}
+	overrides, err := d.endpointOverrides()
+	if err != nil {
+		return nil, err
+	}
+	d.endpoints = newEndpointRewriter(transport, overrides, golangsdk.SignOptions{
+		AccessKey: d.AccessKey,
+		SecretKey: d.SecretKey,
+	})
+	refresher := newTokenRefresher(d.endpoints, func() (string, error) {
+		return d.reauthenticate(opts)
+	})
+	return &contextTransport{base: refresher, ctx: d.context}, nil
+}
+
+// authenticatedClient authenticates the same way as clientconfig.AuthenticatedClient, but IAM requests are sent
+// by the given HTTP client, which is then used by all service clients of the provider
+func authenticatedClient(opts *clientconfig.ClientOpts, httpClient *http.Client) (*golangsdk.ProviderClient, error) {
+	ao, err := clientconfig.AuthOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	switch authOpts := ao.(type) {
+	case golangsdk.AuthOptions:
+		provider, err := openstack.NewClient(authOpts.IdentityEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		provider.HTTPClient = *httpClient
+		if err := openstack.AuthenticateV3(provider, &authOpts, golangsdk.EndpointOpts{}); err != nil {
+			return nil, err
+		}
+		return provider, nil
+	case golangsdk.AKSKAuthOptions:
+		provider, err := openstack.NewClient(authOpts.IdentityEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		provider.HTTPClient = *httpClient
+		// v3 endpoint is used directly, without version discovery
+		provider.IdentityEndpoint = provider.IdentityBase + "v3/"
+		// as for crutch-house client, project and domain names are resolved to IDs and the catalog
+		// is built from services and endpoints; options carry only domain ID, so domain name is added
+		if authOpts.DomainID == "" && opts.AuthInfo != nil {
+			authOpts.Domain = opts.AuthInfo.DomainName
+		}
+		if err := openstack.Authenticate(provider, authOpts); err != nil {
+			return nil, err
+		}
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unsupported authentication options %T", ao)
+	}
+}
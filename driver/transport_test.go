@@ -0,0 +1,190 @@
+package opentelekomcloud
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newIAMDriver(iam *fakeIAM) *Driver {
+	// nothing is saved by drivers of fake API tests, so they have no store
+	driver := NewDriver(instanceName, "")
+	driver.AuthURL = iam.authURL()
+	driver.Region = defaultRegion
+	driver.ProjectName = defaultRegion
+	driver.DomainName = fakeDomainName
+	driver.CredentialsStore = credentialsStoreConfig
+	return driver
+}
+
+func TestDriver_TokenRefresh(t *testing.T) {
+	ttl := 300 * time.Millisecond
+	iam := newFakeIAM(ttl)
+	defer iam.Close()
+
+	driver := newIAMDriver(iam)
+	driver.Username = fakeUsername
+	driver.Password = fakePassword
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	issued := iam.Issued()
+
+	time.Sleep(ttl + 100*time.Millisecond)
+	server, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Equal(t, "ACTIVE", server.Status)
+	assert.Equal(t, issued+1, iam.Issued())
+
+	// refreshed token is reused
+	_, err = driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Equal(t, issued+1, iam.Issued())
+}
+
+func TestDriver_TransportPerDriver(t *testing.T) {
+	ttl := 300 * time.Millisecond
+	first, second := newFakeIAM(ttl), newFakeIAM(ttl)
+	defer first.Close()
+	defer second.Close()
+
+	drivers := []*Driver{newTLSDriver(first), newTLSDriver(second)}
+	for _, driver := range drivers {
+		require.NoError(t, driver.Authenticate())
+		require.NoError(t, driver.initCompute())
+	}
+	// transports are compared by identity, deep comparison reads their connection pools
+	assert.True(t, http.DefaultTransport == defaultTransport)
+	assert.True(t, drivers[0].httpClient.Transport != drivers[1].httpClient.Transport)
+	issued := []int{first.Issued(), second.Issued()}
+
+	// expired token of the first driver is refreshed by its own IAM
+	time.Sleep(ttl + 100*time.Millisecond)
+	_, err := drivers[0].client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+	assert.Equal(t, issued[0]+1, first.Issued())
+	assert.Equal(t, issued[1], second.Issued())
+}
+
+func TestDriver_ExpiredTokenWithPassword(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newIAMDriver(iam)
+	driver.Token = "expired-token"
+	driver.Username = fakeUsername
+	driver.Password = fakePassword
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+}
+
+func TestDriver_ExpiredTokenOnly(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newIAMDriver(iam)
+	driver.Token = "expired-token"
+	err := driver.Authenticate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't be refreshed")
+}
+
+func TestDriver_ExpiredTokenOnlyRefresh(t *testing.T) {
+	ttl := 300 * time.Millisecond
+	iam := newFakeIAM(ttl)
+	defer iam.Close()
+
+	token, _ := iam.issueToken(fakeProjectID)
+	driver := newIAMDriver(iam)
+	driver.Token = token
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+
+	time.Sleep(ttl + 100*time.Millisecond)
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't be refreshed")
+}
+
+func TestDriver_AKSKNames(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	// only names of project and domain are configured
+	driver := newIAMDriver(iam)
+	driver.AccessKey = fakeAccessKey
+	driver.SecretKey = fakeSecretKey
+	require.NoError(t, driver.Authenticate())
+	assert.Equal(t, fakeProjectID, driver.provider.ProjectID)
+	assert.Equal(t, fakeProjectID, driver.provider.AKSKAuthOptions.ProjectId)
+	assert.Equal(t, fakeDomainID, driver.provider.AKSKAuthOptions.DomainID)
+
+	driver = newIAMDriver(iam)
+	driver.AccessKey = fakeAccessKey
+	driver.SecretKey = fakeSecretKey
+	driver.ProjectName = "unknown"
+	assert.Error(t, driver.Authenticate())
+}
+
+func TestDriver_SecurityToken(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	iam.setSecurityToken("fake-security-token")
+
+	driver := newIAMDriver(iam)
+	driver.AccessKey = fakeAccessKey
+	driver.SecretKey = fakeSecretKey
+	driver.SecurityToken = "fake-security-token"
+	require.NoError(t, driver.Authenticate())
+	require.NoError(t, driver.initCompute())
+	_, err := driver.client.GetInstanceStatus("instance")
+	require.NoError(t, err)
+
+	// temporary credentials have expired
+	iam.setSecurityToken("")
+	_, err = driver.client.GetInstanceStatus("instance")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expired")
+}
+
+func TestDriver_SecurityTokenMissing(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+	iam.setSecurityToken("fake-security-token")
+
+	driver := newIAMDriver(iam)
+	driver.AccessKey = fakeAccessKey
+	driver.SecretKey = fakeSecretKey
+	assert.Error(t, driver.Authenticate())
+}
+
+func TestDriver_SecurityTokenConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-token":          "token",
+			"otc-security-token": "fake-security-token",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	assert.Error(t, driver.SetConfigFromFlags(flags))
+
+	flags.FlagsValues = map[string]interface{}{
+		"otc-access-key-id":     fakeAccessKey,
+		"otc-access-key-key":    fakeSecretKey,
+		"otc-security-token":    "fake-security-token",
+		"otc-credentials-store": credentialsStoreEnv,
+	}
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	assert.Empty(t, reloadDriver(t, driver).SecurityToken)
+}
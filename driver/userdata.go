@@ -0,0 +1,221 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// userDataPart is a single part of multipart user data
+type userDataPart struct {
+	ContentType string
+	Content     []byte
+}
+
+// cloud-init content types detected by first line of user data, longer prefixes go first
+var userDataPrefixes = []struct {
+	prefix      string
+	contentType string
+}{
+	{"#!", "text/x-shellscript"},
+	{"#cloud-config-archive", "text/cloud-config-archive"},
+	{"#cloud-config", "text/cloud-config"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#include", "text/x-include-url"},
+	{"#part-handler", "text/part-handler"},
+	{"#upstart-job", "text/upstart-job"},
+}
+
+func detectContentType(userData []byte) string {
+	for _, p := range userDataPrefixes {
+		if bytes.HasPrefix(userData, []byte(p.prefix)) {
+			return p.contentType
+		}
+	}
+	return ""
+}
+
+const sshdPortScript = `#!/bin/sh
+sed -i -e '/^#\?Port /d' /etc/ssh/sshd_config
+echo 'Port %d' >> /etc/ssh/sshd_config
+systemctl restart sshd || systemctl restart ssh || service ssh restart
+`
+
+// generatedUserDataParts returns parts required by driver configuration
+func (d *Driver) generatedUserDataParts() []userDataPart {
+	var parts []userDataPart
+	if d.SSHPortSetup && d.SSHPort != 0 && d.SSHPort != defaultSSHPort {
+		parts = append(parts, userDataPart{
+			ContentType: "text/x-shellscript",
+			Content:     []byte(fmt.Sprintf(sshdPortScript, d.SSHPort)),
+		})
+	}
+	return parts
+}
+
+// parseUserDataPart reads part defined as `<content-type>=<file>`
+func parseUserDataPart(spec string) (*userDataPart, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return nil, fmt.Errorf("invalid user data part `%s`, expected `<content-type>=<file>`", spec)
+	}
+	contentType := kv[0]
+	if !strings.Contains(contentType, "/") {
+		contentType = "text/" + contentType
+	}
+	content, err := ioutil.ReadFile(kv[1])
+	if err != nil {
+		return nil, err
+	}
+	return &userDataPart{ContentType: contentType, Content: content}, nil
+}
+
+// userDataParts collects all user data parts: the main one, user-defined and driver-generated
+func (d *Driver) userDataParts() ([]userDataPart, error) {
+	var parts []userDataPart
+	if len(d.UserData) != 0 {
+		parts = append(parts, userDataPart{
+			ContentType: detectContentType(d.UserData),
+			Content:     d.UserData,
+		})
+	}
+	for _, spec := range d.UserDataParts {
+		part, err := parseUserDataPart(spec)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, *part)
+	}
+	if d.UserDataTemplate {
+		for i, part := range parts {
+			content, err := d.renderUserData(part.Content)
+			if err != nil {
+				return nil, err
+			}
+			parts[i].Content = content
+		}
+	}
+	return append(parts, d.generatedUserDataParts()...), nil
+}
+
+// composeUserData returns single part as is or combines several parts into multipart/mixed document
+func composeUserData(parts []userDataPart) ([]byte, error) {
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	if len(parts) == 1 {
+		part := parts[0]
+		if part.ContentType == "" || part.ContentType == detectContentType(part.Content) {
+			return part.Content, nil
+		}
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for i, part := range parts {
+		if part.ContentType == "" {
+			return nil, fmt.Errorf("unable to detect content type of user data, use `-otc-user-data-part` instead")
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"part-%03d\"", i+1))
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(part.Content); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	result := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(result, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n", writer.Boundary())
+	_, _ = fmt.Fprint(result, "MIME-Version: 1.0\r\n\r\n")
+	_, _ = body.WriteTo(result)
+	return result.Bytes(), nil
+}
+
+// maxUserDataSize is ECS limit for base64-encoded user data
+const maxUserDataSize = 32 * 1024
+
+// fitUserData checks encoded user data size and compresses user data if it's allowed
+func (d *Driver) fitUserData(userData []byte) ([]byte, error) {
+	size := base64.StdEncoding.EncodedLen(len(userData))
+	if size <= maxUserDataSize {
+		return userData, nil
+	}
+	if !d.UserDataGzip {
+		return nil, fmt.Errorf("encoded user data size (%d bytes) exceeds the limit of %d bytes, "+
+			"use `-otc-user-data-gzip` to compress it", size, maxUserDataSize)
+	}
+	buf := &bytes.Buffer{}
+	writer, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if _, err := writer.Write(userData); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	compressedSize := base64.StdEncoding.EncodedLen(buf.Len())
+	if compressedSize > maxUserDataSize {
+		return nil, fmt.Errorf("encoded user data size (%d bytes) exceeds the limit of %d bytes even after compression",
+			compressedSize, maxUserDataSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// userDataContext is the data available to user data templates
+type userDataContext struct {
+	MachineName      string
+	Region           string
+	AvailabilityZone string
+	FlavorName       string
+	ImageName        string
+	SSHUser          string
+	SSHPort          int
+	Tags             []string
+	Env              map[string]string
+}
+
+func (d *Driver) userDataContext() *userDataContext {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	return &userDataContext{
+		MachineName:      d.MachineName,
+		Region:           d.Region,
+		AvailabilityZone: d.AvailabilityZone,
+		FlavorName:       d.FlavorName,
+		ImageName:        d.ImageName,
+		SSHUser:          d.GetSSHUsername(),
+		SSHPort:          d.SSHPort,
+		Tags:             d.Tags,
+		Env:              env,
+	}
+}
+
+// renderUserData executes user data as Go template, failing on any missing key
+func (d *Driver) renderUserData(userData []byte) ([]byte, error) {
+	tpl, err := template.New("user-data").Option("missingkey=error").Parse(string(userData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user data template: %s", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, d.userDataContext()); err != nil {
+		return nil, fmt.Errorf("failed to render user data template: %s", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,235 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_UserDataTemplate(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	require.NoError(t, os.Setenv("DMD_TEST_VALUE", "from-env"))
+	defer func() {
+		_ = os.Unsetenv("DMD_TEST_VALUE")
+	}()
+
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-cloud":              "otc",
+			"otc-tags":               "machine,test",
+			"otc-user-data-raw":      "{{.MachineName}} {{.Region}} {{.AvailabilityZone}} {{index .Tags 1}} {{.Env.DMD_TEST_VALUE}}",
+			"otc-user-data-template": true,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	require.NoError(t, driver.getUserData())
+	expected := instanceName + " " + defaultRegion + " " + defaultAZ + " test from-env"
+	assert.Equal(t, expected, string(driver.UserData))
+
+	// rendering is done only once
+	require.NoError(t, driver.getUserData())
+	assert.Equal(t, expected, string(driver.UserData))
+}
+
+func TestDriver_UserDataTemplateMissingKey(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.UserDataTemplate = true
+
+	driver.UserData = []byte("{{.Env.DMD_SURELY_NOT_DEFINED}}")
+	assert.Error(t, driver.getUserData())
+
+	driver.UserData = []byte("{{.NotAField}}")
+	assert.Error(t, driver.getUserData())
+}
+
+func TestDriver_UserDataNoTemplate(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.UserData = []byte("{{.MachineName}}")
+	require.NoError(t, driver.getUserData())
+	assert.Equal(t, "{{.MachineName}}", string(driver.UserData))
+}
+
+func readMultipartUserData(t *testing.T, userData []byte) map[string][]string {
+	msg, err := mail.ReadMessage(bytes.NewReader(userData))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+
+	parts := make(map[string][]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		contentType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(part)
+		require.NoError(t, err)
+		parts[contentType] = append(parts[contentType], string(content))
+	}
+	return parts
+}
+
+func TestDriver_UserDataMultipart(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	script := "#!/bin/bash\necho {{.MachineName}} > /tmp/name"
+	config := "packages:\n  - htop\n"
+	boothook := "#cloud-boothook\necho boot"
+	dir, err := ioutil.TempDir("", "otc-user-data")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	configFile := filepath.Join(dir, "config.yaml")
+	boothookFile := filepath.Join(dir, "boothook.sh")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(config), 0600))
+	require.NoError(t, ioutil.WriteFile(boothookFile, []byte(boothook), 0600))
+
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-cloud":              "otc",
+			"otc-user-data-raw":      script,
+			"otc-user-data-template": true,
+			"otc-user-data-part": []string{
+				"cloud-config=" + configFile,
+				"text/cloud-boothook=" + boothookFile,
+			},
+			"otc-ssh-port":       2222,
+			"otc-ssh-port-setup": true,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+	require.NoError(t, driver.getUserData())
+
+	parts := readMultipartUserData(t, driver.UserData)
+	assert.Equal(t, []string{config}, parts["text/cloud-config"])
+	assert.Equal(t, []string{boothook}, parts["text/cloud-boothook"])
+	scripts := parts["text/x-shellscript"]
+	require.Len(t, scripts, 2)
+	assert.Equal(t, "#!/bin/bash\necho "+instanceName+" > /tmp/name", scripts[0])
+	assert.Contains(t, scripts[1], "Port 2222")
+}
+
+func TestDriver_SSHPortSetup(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.SSHPort = 2222
+	assert.Empty(t, driver.generatedUserDataParts())
+
+	driver.SSHPortSetup = true
+	assert.Len(t, driver.generatedUserDataParts(), 1)
+
+	driver.SSHPort = defaultSSHPort
+	assert.Empty(t, driver.generatedUserDataParts())
+}
+
+func TestDriver_UserDataSinglePart(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	config := "packages:\n  - htop\n"
+	dir, err := ioutil.TempDir("", "otc-user-data")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(config), 0600))
+
+	driver := NewDriver(instanceName, storePath)
+	driver.UserDataParts = []string{"cloud-config=" + configFile}
+	require.NoError(t, driver.getUserData())
+
+	// content has no `#cloud-config` header, so it must be wrapped to keep the declared type
+	parts := readMultipartUserData(t, driver.UserData)
+	assert.Equal(t, []string{config}, parts["text/cloud-config"])
+}
+
+func TestDriver_UserDataUnknownType(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.UserData = []byte("unknown content")
+	driver.SSHPort = 2222
+	require.NoError(t, driver.getUserData())
+	assert.Equal(t, "unknown content", string(driver.UserData))
+
+	// content of unknown type can't be combined with sshd script
+	driver = NewDriver(instanceName, storePath)
+	driver.UserData = []byte("unknown content")
+	driver.SSHPort = 2222
+	driver.SSHPortSetup = true
+	assert.Error(t, driver.getUserData())
+
+	_, err := parseUserDataPart("no-file-given")
+	assert.Error(t, err)
+}
+
+func TestDriver_UserDataSize(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	compressible := "#!/bin/sh\n" + strings.Repeat("echo 'compressible' >> /tmp/file\n", 2000)
+
+	driver := NewDriver(instanceName, storePath)
+	driver.UserData = []byte(compressible)
+	assert.Error(t, driver.getUserData())
+
+	driver = NewDriver(instanceName, storePath)
+	driver.UserData = []byte(compressible)
+	driver.UserDataGzip = true
+	require.NoError(t, driver.getUserData())
+	assert.True(t, base64.StdEncoding.EncodedLen(len(driver.UserData)) <= maxUserDataSize)
+	reader, err := gzip.NewReader(bytes.NewReader(driver.UserData))
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, compressible, string(decompressed))
+
+	random := make([]byte, maxUserDataSize)
+	_, _ = rand.Read(random)
+	driver = NewDriver(instanceName, storePath)
+	driver.UserData = append([]byte("#!/bin/sh\n"), random...)
+	driver.UserDataGzip = true
+	assert.Error(t, driver.getUserData())
+}
+
+func TestDriver_UserDataSizeCheckedOnConfig(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-cloud":         "otc",
+			"otc-user-data-raw": "#!/bin/sh\n" + strings.Repeat("a", maxUserDataSize),
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	assert.Error(t, driver.SetConfigFromFlags(flags))
+}
@@ -0,0 +1,212 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/huaweicloud/golangsdk"
+	"github.com/huaweicloud/golangsdk/openstack/compute/v2/extensions/secgroups"
+)
+
+// Phases with separate wait timeouts and poll intervals
+const (
+	phaseInstance = "instance"
+	phaseVPC      = "vpc"
+	phaseSubnet   = "subnet"
+	phaseEIP      = "eip"
+	phaseSecGroup = "secgroup"
+	// phaseReboot is the time given to soft reboot before rebooting hard
+	phaseReboot = "reboot"
+	phaseResize = "resize"
+	phaseImage  = "image"
+	phaseBackup = "backup"
+	// phaseBatch is the time given to ECS job creating all instances of a fleet
+	phaseBatch = "batch"
+)
+
+type waitOptions struct {
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+// defaultWaitOptions match timeouts used by the client library before they became configurable
+var defaultWaitOptions = map[string]waitOptions{
+	phaseInstance: {Timeout: 5 * time.Minute, Interval: time.Second},
+	phaseVPC:      {Timeout: 250 * time.Second, Interval: 5 * time.Second},
+	phaseSubnet:   {Timeout: 250 * time.Second, Interval: 5 * time.Second},
+	phaseEIP:      {Timeout: 30 * time.Second, Interval: time.Second},
+	phaseSecGroup: {Timeout: time.Minute, Interval: time.Second},
+	phaseReboot:   {Timeout: 2 * time.Minute, Interval: time.Second},
+	phaseResize:   {Timeout: 10 * time.Minute, Interval: 5 * time.Second},
+	phaseImage:    {Timeout: 30 * time.Minute, Interval: 10 * time.Second},
+	phaseBackup:   {Timeout: 30 * time.Minute, Interval: 10 * time.Second},
+	phaseBatch:    {Timeout: 10 * time.Minute, Interval: 5 * time.Second},
+}
+
+var waitPhases = []string{phaseInstance, phaseVPC, phaseSubnet, phaseEIP, phaseSecGroup, phaseReboot, phaseResize, phaseImage, phaseBackup, phaseBatch}
+
+// parseWaitDurations parses durations in form `<duration>` for all phases or `<phase>=<duration>`
+func parseWaitDurations(specs []string) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration)
+	for _, spec := range specs {
+		phases := waitPhases
+		value := spec
+		if kv := strings.SplitN(spec, "=", 2); len(kv) == 2 {
+			if _, ok := defaultWaitOptions[kv[0]]; !ok {
+				return nil, fmt.Errorf("unknown wait phase `%s`, supported phases are: %s",
+					kv[0], strings.Join(waitPhases, ", "))
+			}
+			phases, value = []string{kv[0]}, kv[1]
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration in `%s`, expected `<duration>` or `<phase>=<duration>`", spec)
+		}
+		for _, phase := range phases {
+			durations[phase] = duration
+		}
+	}
+	return durations, nil
+}
+
+// waitOptions returns timeout and poll interval of the phase
+func (d *Driver) waitOptions(phase string) (waitOptions, error) {
+	opts := defaultWaitOptions[phase]
+	timeouts, err := parseWaitDurations(d.WaitTimeouts)
+	if err != nil {
+		return opts, err
+	}
+	intervals, err := parseWaitDurations(d.PollIntervals)
+	if err != nil {
+		return opts, err
+	}
+	if timeout, ok := timeouts[phase]; ok {
+		opts.Timeout = timeout
+	}
+	if interval, ok := intervals[phase]; ok {
+		opts.Interval = interval
+	}
+	return opts, nil
+}
+
+func (d *Driver) checkWaitConfig() error {
+	for _, phase := range waitPhases {
+		if _, err := d.waitOptions(phase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitTimeoutError is returned when waiting takes longer than the phase timeout
+type waitTimeoutError struct {
+	description string
+	timeout     time.Duration
+	status      string
+}
+
+func (e *waitTimeoutError) Error() string {
+	return fmt.Sprintf("timeout waiting for %s after %s, last seen status: `%s`", e.description, e.timeout, e.status)
+}
+
+// waitCheck returns true when waiting is over, current status is reported on timeout
+type waitCheck func() (done bool, status string, err error)
+
+// waitFor polls `check` until it's done, timeout of the phase is reached or driver operation is interrupted
+func (d *Driver) waitFor(phase, description string, check waitCheck) error {
+	opts, err := d.waitOptions(phase)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(opts.Timeout)
+	for {
+		done, status, err := check()
+		if err != nil || done {
+			return err
+		}
+		if time.Now().Add(opts.Interval).After(deadline) {
+			return &waitTimeoutError{description: description, timeout: opts.Timeout, status: status}
+		}
+		timer := time.NewTimer(opts.Interval)
+		select {
+		case <-d.context().Done():
+			timer.Stop()
+			return errInterrupted
+		case <-timer.C:
+		}
+	}
+}
+
+func statusDescription(resource, id, status string) string {
+	if status == "" {
+		return fmt.Sprintf("%s `%s` to be deleted", resource, id)
+	}
+	return fmt.Sprintf("%s `%s` to become %s", resource, id, status)
+}
+
+// waitForInstanceStatus waits for instance status, waiting for empty status ends with 404 error
+func (d *Driver) waitForInstanceStatus(instanceID, status string) error {
+	return d.waitFor(phaseInstance, statusDescription("instance", instanceID, status), func() (bool, string, error) {
+		instance, err := d.client.GetInstanceStatus(instanceID)
+		if err != nil {
+			return false, "", err
+		}
+		if instance.Status == "ERROR" {
+			return false, instance.Status, fmt.Errorf("instance `%s` is in ERROR state", instanceID)
+		}
+		return instance.Status == status, instance.Status, nil
+	})
+}
+
+// waitForVPCStatus waits for VPC status, waiting for empty status ends with 404 error
+func (d *Driver) waitForVPCStatus(vpcID, status string) error {
+	return d.waitFor(phaseVPC, statusDescription("VPC", vpcID, status), func() (bool, string, error) {
+		vpc, err := d.client.GetVPCDetails(vpcID)
+		if err != nil {
+			return false, "", err
+		}
+		if vpc.Status == "ERROR" {
+			return false, vpc.Status, fmt.Errorf("VPC `%s` is in ERROR state", vpcID)
+		}
+		return vpc.Status == status, vpc.Status, nil
+	})
+}
+
+// waitForSubnetStatus waits for subnet status, waiting for empty status ends with 404 error
+func (d *Driver) waitForSubnetStatus(subnetID, status string) error {
+	return d.waitFor(phaseSubnet, statusDescription("subnet", subnetID, status), func() (bool, string, error) {
+		subnet, err := d.client.GetSubnetStatus(subnetID)
+		if err != nil {
+			return false, "", err
+		}
+		if subnet.Status == "ERROR" {
+			return false, subnet.Status, fmt.Errorf("subnet `%s` is in ERROR state", subnetID)
+		}
+		return subnet.Status == status, subnet.Status, nil
+	})
+}
+
+func (d *Driver) waitForEIPActive(eipID string) error {
+	return d.waitFor(phaseEIP, statusDescription("EIP", eipID, "ACTIVE"), func() (bool, string, error) {
+		status, err := d.client.GetEIPStatus(eipID)
+		if err != nil {
+			return false, "", err
+		}
+		return status == "ACTIVE" || status == "DOWN", status, nil
+	})
+}
+
+func (d *Driver) waitForGroupDeleted(groupID string) error {
+	return d.waitFor(phaseSecGroup, statusDescription("security group", groupID, ""), func() (bool, string, error) {
+		err := secgroups.Get(d.computeV2, groupID).Err
+		switch err.(type) {
+		case nil:
+			return false, "exists", nil
+		case golangsdk.ErrDefault404:
+			return true, "", nil
+		default:
+			return false, "", err
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWaitDurations(t *testing.T) {
+	durations, err := parseWaitDurations([]string{"20m", "eip=1m", "secgroup=30s"})
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Minute, durations[phaseInstance])
+	assert.Equal(t, 20*time.Minute, durations[phaseVPC])
+	assert.Equal(t, time.Minute, durations[phaseEIP])
+	assert.Equal(t, 30*time.Second, durations[phaseSecGroup])
+
+	for _, spec := range []string{"forever", "-1s", "0", "volume=1m", "instance="} {
+		_, err := parseWaitDurations([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestDriver_WaitOptions(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	flags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"otc-token":             "token",
+			"otc-wait-timeout":      []string{"instance=15m"},
+			"otc-poll-interval":     []string{"10s"},
+			"otc-credentials-store": credentialsStoreConfig,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+	require.NoError(t, driver.SetConfigFromFlags(flags))
+
+	opts, err := reloadDriver(t, driver).waitOptions(phaseInstance)
+	require.NoError(t, err)
+	assert.Equal(t, waitOptions{Timeout: 15 * time.Minute, Interval: 10 * time.Second}, opts)
+	opts, err = driver.waitOptions(phaseEIP)
+	require.NoError(t, err)
+	assert.Equal(t, waitOptions{Timeout: defaultWaitOptions[phaseEIP].Timeout, Interval: 10 * time.Second}, opts)
+
+	flags.FlagsValues["otc-wait-timeout"] = []string{"volume=15m"}
+	assert.Error(t, driver.SetConfigFromFlags(flags))
+}
+
+func TestDriver_WaitFor(t *testing.T) {
+	storePath, cleanup := tempStorePath(t)
+	defer cleanup()
+	driver := NewDriver(instanceName, storePath)
+	driver.WaitTimeouts = []string{"200ms"}
+	driver.PollIntervals = []string{"10ms"}
+
+	statuses := []string{"BUILD", "BUILD", "ACTIVE"}
+	polls := 0
+	err := driver.waitFor(phaseInstance, "test", func() (bool, string, error) {
+		status := statuses[polls]
+		polls++
+		return status == "ACTIVE", status, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, polls)
+
+	err = driver.waitFor(phaseInstance, "test", func() (bool, string, error) {
+		return false, "", fmt.Errorf("failed")
+	})
+	assert.EqualError(t, err, "failed")
+}
+
+func TestDriver_WaitForTimeout(t *testing.T) {
+	iam := newFakeIAM(time.Minute)
+	defer iam.Close()
+
+	driver := newTLSDriver(iam)
+	driver.WaitTimeouts = []string{"instance=100ms"}
+	driver.PollIntervals = []string{"instance=10ms"}
+	require.NoError(t, driver.initCompute())
+	require.NoError(t, driver.waitForInstanceStatus("instance", "ACTIVE"))
+
+	start := time.Now()
+	err := driver.waitForInstanceStatus("instance", "SHUTOFF")
+	require.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Contains(t, err.Error(), "last seen status: `ACTIVE`")
+	assert.Contains(t, err.Error(), "100ms")
+}
@@ -1,6 +1,6 @@
 module github.com/opentelekomcloud/docker-machine-opentelekomcloud
 
-go 1.12
+go 1.13
 
 replace github.com/Sirupsen/logrus => github.com/sirupsen/logrus v1.4.2
 
@@ -14,4 +14,8 @@ require (
 	github.com/opentelekomcloud-infra/crutch-house v0.1.0
 	github.com/sirupsen/logrus v1.5.0 // indirect
 	github.com/stretchr/testify v1.5.1
+	github.com/zalando/go-keyring v0.1.0
+	golang.org/x/crypto v0.0.0-20200414173820-0848c9571904
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/docker/machine/libmachine/drivers/plugin"
 	opentelekomcloud "github.com/opentelekomcloud/docker-machine-opentelekomcloud/driver"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := opentelekomcloud.RunCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	plugin.RegisterDriver(opentelekomcloud.NewDriver("default", ""))
 }